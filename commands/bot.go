@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -86,6 +87,8 @@ func setupRoutes(apiKey string) {
 			handleGetVersion(w, r)
 		} else if r.Method == "POST" {
 			handleScenario(w, r)
+		} else if r.Method == "DELETE" {
+			handleCancel(w, r)
 		} else {
 			handleNotFound(w, r)
 		}
@@ -107,8 +110,13 @@ func handleGetVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleScenario(w http.ResponseWriter, r *http.Request) {
+	// the run stops if htflood disconnects, or cancels it, which it can do as
+	// soon as the run holds the lock, even before it starts
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
 	//prevent concurrent execution because it would mess up measurements
-	if !tryAcquireExecLock() {
+	if !tryAcquireExecLock(cancel) {
 		handleServiceUnavailable(w, r)
 		return
 	}
@@ -129,23 +137,25 @@ func handleScenario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = req.Execute(scenario, w)
+	err = req.Execute(ctx, scenario, w)
 	if err != nil {
 		handleMidstreamInternalError("Failed to execute scenario", err, w)
 	}
 }
 
 var (
-	execFlag = false
-	execLock = &sync.Mutex{}
+	execFlag   = false
+	execCancel context.CancelFunc
+	execLock   = &sync.Mutex{}
 )
 
-func tryAcquireExecLock() bool {
+func tryAcquireExecLock(cancel context.CancelFunc) bool {
 	execLock.Lock()
 	defer execLock.Unlock()
 	success := !execFlag
 	if success {
 		execFlag = true
+		execCancel = cancel
 	}
 	return success
 }
@@ -157,6 +167,27 @@ func releaseExecLock() {
 		panic("tried releasing execFlag without owning it")
 	}
 	execFlag = false
+	execCancel = nil
+}
+
+// cancelExec stops the current run, if any: it stops sending requests, and
+// completes once those in flight did.
+func cancelExec() bool {
+	execLock.Lock()
+	defer execLock.Unlock()
+	if execCancel == nil {
+		return false
+	}
+	execCancel()
+	return true
+}
+
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	if !cancelExec() {
+		handleNotFound(w, r)
+		return
+	}
+	w.Write([]byte("Cancelled"))
 }
 
 func handleServiceUnavailable(w http.ResponseWriter, r *http.Request) {
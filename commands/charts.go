@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// svgChart is the geometry of a chart, ready to be drawn as inline svg by
+// the report template.
+type svgChart struct {
+	Width   int
+	Height  int
+	Left    int
+	Bottom  int
+	Right   int
+	Top     int
+	Series  []svgSeries
+	Bars    []svgBar
+	XLabels []svgLabel
+	YLabels []svgLabel
+}
+
+type svgSeries struct {
+	Name   string
+	Color  string
+	Points string
+}
+
+type svgBar struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+	Color  string
+	Title  string
+}
+
+type svgLabel struct {
+	X    float64
+	Y    float64
+	Text string
+}
+
+type chartSeries struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+const (
+	chartWidth   = 860
+	chartHeight  = 260
+	chartLeft    = 60
+	chartBottom  = 30
+	chartTop     = 10
+	chartRight   = 10
+	chartXLabels = 8
+	chartYLabels = 5
+)
+
+func newChart() svgChart {
+	return svgChart{
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   chartLeft,
+		Bottom: chartHeight - chartBottom,
+		Right:  chartWidth - chartRight,
+		Top:    chartTop,
+	}
+}
+
+// newLineChart plots the series against xs, which must be sorted.
+func newLineChart(xs []float64, series []chartSeries, xFormat func(float64) string) svgChart {
+	chart := newChart()
+	if len(xs) == 0 {
+		return chart
+	}
+
+	xMin, xMax := xs[0], xs[len(xs)-1]
+	if xMax == xMin {
+		xMax = xMin + 1
+	}
+	yMax := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			yMax = math.Max(yMax, v)
+		}
+	}
+	yMax = niceCeil(yMax)
+
+	for _, s := range series {
+		points := make([]string, 0, len(s.Values))
+		for i, v := range s.Values {
+			x := chart.scaleX(xs[i], xMin, xMax)
+			y := chart.scaleY(v, yMax)
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+		chart.Series = append(chart.Series, svgSeries{Name: s.Name, Color: s.Color, Points: strings.Join(points, " ")})
+	}
+
+	for i := 0; i <= chartXLabels; i++ {
+		value := xMin + (xMax-xMin)*float64(i)/chartXLabels
+		chart.XLabels = append(chart.XLabels, svgLabel{X: round(chart.scaleX(value, xMin, xMax), 1), Y: float64(chart.Bottom + 18), Text: xFormat(value)})
+	}
+	chart.addYLabels(yMax)
+
+	return chart
+}
+
+// newBarChart draws one bar per value, labelling every few bars so that the
+// labels don't overlap.
+func newBarChart(labels []string, values []float64, color string) svgChart {
+	chart := newChart()
+	if len(values) == 0 {
+		return chart
+	}
+
+	yMax := 0.0
+	for _, v := range values {
+		yMax = math.Max(yMax, v)
+	}
+	yMax = niceCeil(yMax)
+
+	slot := float64(chart.Right-chart.Left) / float64(len(values))
+	labelEvery := int(math.Ceil(float64(len(values)) / chartXLabels))
+	for i, v := range values {
+		x := float64(chart.Left) + slot*float64(i)
+		y := chart.scaleY(v, yMax)
+		chart.Bars = append(chart.Bars, svgBar{
+			X:      round(x+slot*0.1, 1),
+			Y:      round(y, 1),
+			Width:  round(slot*0.8, 1),
+			Height: round(float64(chart.Bottom)-y, 1),
+			Color:  color,
+			Title:  fmt.Sprintf("%v: %v", labels[i], formatNumber(v)),
+		})
+		if i%labelEvery == 0 {
+			chart.XLabels = append(chart.XLabels, svgLabel{X: round(x+slot/2, 1), Y: float64(chart.Bottom + 18), Text: labels[i]})
+		}
+	}
+	chart.addYLabels(yMax)
+
+	return chart
+}
+
+func (chart *svgChart) scaleX(value, min, max float64) float64 {
+	return float64(chart.Left) + (value-min)/(max-min)*float64(chart.Right-chart.Left)
+}
+
+func (chart *svgChart) scaleY(value, max float64) float64 {
+	return float64(chart.Bottom) - value/max*float64(chart.Bottom-chart.Top)
+}
+
+func (chart *svgChart) addYLabels(yMax float64) {
+	for i := 0; i <= chartYLabels; i++ {
+		value := yMax * float64(i) / chartYLabels
+		chart.YLabels = append(chart.YLabels, svgLabel{X: float64(chart.Left - 6), Y: round(chart.scaleY(value, yMax)+4, 1), Text: formatNumber(value)})
+	}
+}
+
+// niceCeil rounds max up to 1, 2 or 5 times a power of ten, for round axis
+// labels.
+func niceCeil(max float64) float64 {
+	if max <= 0 {
+		return 1
+	}
+	magnitude := math.Pow10(int(math.Floor(math.Log10(max))))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if max <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatNumber(value float64) string {
+	switch {
+	case value == math.Trunc(value) && math.Abs(value) < 1e9:
+		return fmt.Sprintf("%d", int64(value))
+	case math.Abs(value) >= 100:
+		return fmt.Sprintf("%.0f", value)
+	case math.Abs(value) >= 1:
+		return fmt.Sprintf("%.1f", value)
+	default:
+		return fmt.Sprintf("%.3f", value)
+	}
+}
@@ -0,0 +1,315 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vincentcr/htflood/req"
+)
+
+var compareCommand = &cobra.Command{
+	Use:   "compare <baseline> <candidate>",
+	Short: "compare the outputs of two runs of req",
+	Long: `compare reads two files of output produced by the req command, and outputs the
+difference of each metric between the baseline and the candidate. It fails
+if the candidate regressed more than allowed by --max-regression.`,
+	Run: checkedRun(runCompare),
+}
+
+var compareOptions struct {
+	percentiles   string
+	maxRegression string
+	significance  float64
+}
+
+func init() {
+	compareCommand.Flags().StringVar(&compareOptions.percentiles, "percentiles", defaultPercentiles, "latency percentiles to compare, comma-separated")
+	compareCommand.Flags().StringVar(&compareOptions.maxRegression, "max-regression", "",
+		"allowed regressions of average, max, any percentile (p95...), errorRate or throughput, e.g. p99=10%,errorRate=1 (latencies and throughput: relative change; errorRate: percentage points)")
+	compareCommand.Flags().Float64Var(&compareOptions.significance, "significance", 0.05,
+		"regressions of the average and median latencies only fail the comparison if the latency distributions differ with this p-value (0 to disable)")
+}
+
+// MetricComparison is the change of a metric between the baseline and the
+// candidate. Delta is relative (in percent) for latencies and throughput, and
+// absolute (in percentage points) for the error rate.
+type MetricComparison struct {
+	Name      string
+	Baseline  float64
+	Candidate float64
+	Delta     float64
+	Limit     *float64 `json:",omitempty"`
+	Regressed bool
+	relative  bool
+}
+
+// SignificanceTest tells whether the latency distributions of the two runs
+// differ, using a Mann-Whitney U test.
+type SignificanceTest struct {
+	Test        string
+	Z           float64
+	PValue      float64
+	Significant bool
+}
+
+type Comparison struct {
+	Metrics      []MetricComparison
+	Significance SignificanceTest
+	Passed       bool
+}
+
+func runCompare(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected a baseline and a candidate file")
+	}
+
+	percentiles, err := parsePercentiles(compareOptions.percentiles)
+	if err != nil {
+		return err
+	}
+	limits, err := parseRegressionLimits(compareOptions.maxRegression)
+	if err != nil {
+		return err
+	}
+	percentiles = withLimitPercentiles(percentiles, limits)
+
+	baseline, err := accumulateFile(args[0])
+	if err != nil {
+		return err
+	}
+	candidate, err := accumulateFile(args[1])
+	if err != nil {
+		return err
+	}
+
+	comparison := compare(baseline, candidate, percentiles, limits, compareOptions.significance)
+	printComparison(comparison)
+
+	if !comparison.Passed {
+		return fmt.Errorf("regression detected: %v", describeRegressions(comparison))
+	}
+	return nil
+}
+
+func accumulateFile(filename string) (*Accumulator, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %v: %v", filename, err)
+	}
+	defer file.Close()
+
+	acc := newAccumulator()
+	for res := range readResponses(file) {
+		accumulate(acc, res)
+	}
+
+	if acc.Count == 0 {
+		return nil, fmt.Errorf("%v: empty data", filename)
+	}
+	return acc, nil
+}
+
+// parseRegressionLimits parses a list of metric=limit, where limits can be
+// suffixed with % for readability. Unknown metrics are rejected, as their
+// limit would silently never apply.
+func parseRegressionLimits(str string) (map[string]float64, error) {
+	limits := map[string]float64{}
+	for _, field := range strings.Split(str, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid regression limit '%v': expected <metric>=<limit>", field)
+		}
+		limit, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(parts[1]), "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regression limit '%v': %v", field, err)
+		}
+		name, err := regressionMetric(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regression limit '%v': %v", field, err)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// regressionMetric checks the name of a compared metric, and normalizes the
+// percentiles, so that e.g. p99.0 is p99.
+func regressionMetric(name string) (string, error) {
+	switch name {
+	case "average", "max", "errorRate", "throughput":
+		return name, nil
+	}
+	if p, ok := parsePercentileName(name); ok {
+		return percentileName(p), nil
+	}
+	return "", fmt.Errorf("unknown metric %v", name)
+}
+
+func parsePercentileName(name string) (float64, bool) {
+	if !strings.HasPrefix(name, "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(name[1:], 64)
+	return p, err == nil && p >= 0 && p <= 100
+}
+
+// withLimitPercentiles adds the percentiles which have a regression limit to
+// the compared percentiles.
+func withLimitPercentiles(percentiles []float64, limits map[string]float64) []float64 {
+	compared := map[string]bool{}
+	for _, p := range percentiles {
+		compared[percentileName(p)] = true
+	}
+	for name := range limits {
+		if p, ok := parsePercentileName(name); ok && !compared[name] {
+			percentiles = append(percentiles, p)
+			compared[name] = true
+		}
+	}
+	sort.Float64s(percentiles)
+	return percentiles
+}
+
+func compare(baseline, candidate *Accumulator, percentiles []float64, limits map[string]float64, significance float64) Comparison {
+	baseStats := finalize(baseline, percentiles)
+	candStats := finalize(candidate, percentiles)
+
+	z, pValue := mannWhitney(baseline.Latency, candidate.Latency)
+	comparison := Comparison{
+		Significance: SignificanceTest{
+			Test:        "mann-whitney",
+			Z:           round(z, Precision),
+			PValue:      round(pValue, Precision),
+			Significant: significance <= 0 || pValue < significance,
+		},
+		Passed: true,
+	}
+
+	// the significance test compares whole distributions, which says little
+	// about their tails: only the central metrics depend on it
+	addMetric := func(name string, base, cand float64, relative bool, higherIsWorse bool, needsSignificance bool) {
+		metric := MetricComparison{Name: name, Baseline: base, Candidate: cand, relative: relative}
+		if relative {
+			metric.Delta = round(relativeChange(base, cand), Precision)
+		} else {
+			metric.Delta = round(cand-base, Precision)
+		}
+
+		if limit, ok := limits[name]; ok {
+			metric.Limit = &limit
+			regression := metric.Delta
+			if !higherIsWorse {
+				regression = -regression
+			}
+			metric.Regressed = regression > limit && (!needsSignificance || comparison.Significance.Significant)
+		}
+
+		if metric.Regressed {
+			comparison.Passed = false
+		}
+		comparison.Metrics = append(comparison.Metrics, metric)
+	}
+
+	addMetric("average", baseStats.Latency.Average, candStats.Latency.Average, true, true, true)
+	for _, p := range percentiles {
+		name := percentileName(p)
+		addMetric(name, baseStats.Latency.Percentiles[name], candStats.Latency.Percentiles[name], true, true, p <= 50)
+	}
+	addMetric("max", baseStats.Latency.Max, candStats.Latency.Max, true, true, false)
+	addMetric("errorRate", baseStats.ErrorRate, candStats.ErrorRate, false, true, false)
+	addMetric("throughput", baseStats.Throughput, candStats.Throughput, true, false, false)
+
+	return comparison
+}
+
+// relativeChange returns the change from base to cand, in percent. Any
+// change from 0 counts as 100%.
+func relativeChange(base, cand float64) float64 {
+	if base == 0 {
+		if cand == 0 {
+			return 0
+		}
+		return math.Copysign(100, cand)
+	}
+	return 100 * (cand - base) / base
+}
+
+// mannWhitney runs a Mann-Whitney U test on two histograms of the same scale
+// and precision, using the normal approximation with a correction for ties.
+// Values of the same bucket are ties. It returns the z score (positive when
+// the values of b tend to be higher) and the two-sided p-value.
+func mannWhitney(a, b *req.Histogram) (float64, float64) {
+	n1, n2 := float64(a.Count()), float64(b.Count())
+	n := n1 + n2
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+
+	buckets := a.BucketCount()
+	if b.BucketCount() > buckets {
+		buckets = b.BucketCount()
+	}
+
+	// rank the buckets in increasing order, each tie getting the average rank
+	var rankSumB, tieCorrection, ranked float64
+	for idx := 0; idx < buckets; idx++ {
+		countA := float64(a.CountAt(idx))
+		countB := float64(b.CountAt(idx))
+		ties := countA + countB
+		if ties == 0 {
+			continue
+		}
+
+		averageRank := ranked + (ties+1)/2
+		rankSumB += countB * averageRank
+		tieCorrection += ties*ties*ties - ties
+		ranked += ties
+	}
+
+	u := rankSumB - n2*(n2+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 0, 1
+	}
+
+	z := (u - mean) / math.Sqrt(variance)
+	pValue := math.Erfc(math.Abs(z) / math.Sqrt2)
+	return z, pValue
+}
+
+func describeRegressions(comparison Comparison) string {
+	var regressions []string
+	for _, metric := range comparison.Metrics {
+		if metric.Regressed {
+			unit := " points"
+			if metric.relative {
+				unit = "%"
+			}
+			regressions = append(regressions, fmt.Sprintf("%v changed by %v%v (limit: %v%v)", metric.Name, metric.Delta, unit, *metric.Limit, unit))
+		}
+	}
+	return strings.Join(regressions, ", ")
+}
+
+func printComparison(comparison Comparison) {
+	output := map[string]interface{}{"Comparison": comparison}
+	bytes, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		fatal(fmt.Errorf("Unable to format comparison '%#v' to json: %v", comparison, err))
+	} else {
+		os.Stdout.Write(bytes)
+		os.Stdout.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"math"
+	"testing"
+
+	"github.com/vincentcr/htflood/req"
+)
+
+func newTestHistogram(values ...float64) *req.Histogram {
+	h := req.NewHistogram(req.DurationScale)
+	for _, value := range values {
+		h.Record(value)
+	}
+	return h
+}
+
+func TestMannWhitney(t *testing.T) {
+	// no overlap: the z score only depends on the sizes of the samples
+	a := newTestHistogram(1, 2, 3, 4, 5)
+	b := newTestHistogram(6, 7, 8, 9, 10)
+	z, p := mannWhitney(a, b)
+	// U = 25, with a mean of 12.5 and a variance of 25*11/12
+	expectedZ := 12.5 / math.Sqrt(25.0*11/12)
+	if math.Abs(z-expectedZ) > 1e-9 {
+		t.Errorf("expected z %v, got %v", expectedZ, z)
+	}
+	if p >= 0.05 {
+		t.Errorf("expected a significant difference, got p %v", p)
+	}
+
+	// the sign tells which sample is higher
+	if z, _ := mannWhitney(b, a); math.Abs(z+expectedZ) > 1e-9 {
+		t.Errorf("expected z %v, got %v", -expectedZ, z)
+	}
+}
+
+func TestMannWhitneySameDistribution(t *testing.T) {
+	var values []float64
+	for i := 1; i <= 200; i++ {
+		values = append(values, float64(i%50))
+	}
+	z, p := mannWhitney(newTestHistogram(values...), newTestHistogram(values...))
+	if z != 0 || p != 1 {
+		t.Errorf("expected z 0 and p 1, got %v and %v", z, p)
+	}
+}
+
+func TestMannWhitneyTies(t *testing.T) {
+	// all values tie: there's no difference to measure
+	z, p := mannWhitney(newTestHistogram(5, 5, 5), newTestHistogram(5, 5))
+	if z != 0 || p != 1 {
+		t.Errorf("expected z 0 and p 1, got %v and %v", z, p)
+	}
+
+	// ties get the average of their ranks: the 5s of a and b share ranks 2
+	// to 5, so b's rank sum is 2*3.5 + 7 + 8 and U = 22 - 10 = 12, against a
+	// mean of 8
+	a := newTestHistogram(1, 5, 5, 6)
+	b := newTestHistogram(5, 5, 7, 8)
+	z, _ = mannWhitney(a, b)
+	tieCorrection := (4.0*4*4 - 4) / (8 * 7)
+	expectedZ := 4 / math.Sqrt(16.0/12*(9-tieCorrection))
+	if math.Abs(z-expectedZ) > 1e-9 {
+		t.Errorf("expected z %v, got %v", expectedZ, z)
+	}
+}
+
+func TestMannWhitneyEmpty(t *testing.T) {
+	z, p := mannWhitney(newTestHistogram(), newTestHistogram(1, 2, 3))
+	if z != 0 || p != 1 {
+		t.Errorf("expected z 0 and p 1, got %v and %v", z, p)
+	}
+}
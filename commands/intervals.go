@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/vincentcr/htflood/req"
+)
+
+// IntervalStats are the stats of the requests started during one interval.
+type IntervalStats struct {
+	Start       float64
+	Count       int
+	Throughput  float64
+	Errors      int
+	StatusCodes map[string]int
+	Latency     Stat
+}
+
+// accumulateIntervals groups the responses by interval of their timestamp.
+func accumulateIntervals(responses chan req.ResponseInfo, interval time.Duration) map[int64]*summaryAccumulator {
+	intervals := map[int64]*summaryAccumulator{}
+
+	for res := range responses {
+		recordInterval(intervals, res, interval)
+	}
+
+	return intervals
+}
+
+func recordInterval(intervals map[int64]*summaryAccumulator, res req.ResponseInfo, interval time.Duration) {
+	if res.Transaction {
+		return
+	}
+	key := intervalKey(res.Timestamp, interval)
+	acc, ok := intervals[key]
+	if !ok {
+		acc = newSummaryAccumulator()
+		intervals[key] = acc
+	}
+	acc.record(res)
+}
+
+func intervalKey(timestamp float64, interval time.Duration) int64 {
+	return int64(math.Floor(timestamp / interval.Seconds()))
+}
+
+func finalizeIntervals(intervals map[int64]*summaryAccumulator, interval time.Duration, percentiles []float64) []IntervalStats {
+	keys := make([]int64, 0, len(intervals))
+	for key := range intervals {
+		keys = append(keys, key)
+	}
+	sort.Sort(int64Slice(keys))
+
+	stats := make([]IntervalStats, 0, len(keys))
+	for _, key := range keys {
+		acc := intervals[key]
+		stats = append(stats, IntervalStats{
+			Start:       round(float64(key)*interval.Seconds(), 3),
+			Count:       acc.Count,
+			Throughput:  round(float64(acc.Count)/interval.Seconds(), Precision),
+			Errors:      acc.Errors,
+			StatusCodes: acc.StatusCodes,
+			Latency:     finalizeStat(acc.Latency, percentiles),
+		})
+	}
+	return stats
+}
+
+type int64Slice []int64
+
+func (s int64Slice) Len() int           { return len(s) }
+func (s int64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// printIntervals outputs one json row per interval.
+func printIntervals(stats []IntervalStats) {
+	for _, stat := range stats {
+		bytes, err := json.Marshal(stat)
+		if err != nil {
+			fatal(fmt.Errorf("Unable to format interval stats '%#v' to json: %v", stat, err))
+		}
+		os.Stdout.Write(bytes)
+		os.Stdout.Write([]byte("\n"))
+	}
+}
@@ -17,6 +17,8 @@ func setupCommands(rootCmd *cobra.Command) {
 	rootCmd.AddCommand(botCommand)
 	rootCmd.AddCommand(statsCommand)
 	rootCmd.AddCommand(reqCommand)
+	rootCmd.AddCommand(reportCommand)
+	rootCmd.AddCommand(compareCommand)
 }
 
 func checkedRun(run func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) {
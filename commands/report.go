@@ -0,0 +1,418 @@
+package commands
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vincentcr/htflood/req"
+)
+
+var reportCommand = &cobra.Command{
+	Use:   "report",
+	Short: "output an html report from the output of req",
+	Long: `report reads from stdin for output produced by the req command, and writes
+				a self-contained html report, with charts and tables`,
+	Run: checkedRun(runReport),
+}
+
+var reportOptions struct {
+	output      string
+	title       string
+	percentiles string
+}
+
+const (
+	reportMaxPoints   = 150
+	reportMaxErrors   = 50
+	reportMaxUrls     = 50
+	reportHistBins    = 40
+	reportExampleSize = 300
+)
+
+func init() {
+	reportCommand.Flags().StringVar(&reportOptions.output, "output", "report.html", "the html file to write")
+	reportCommand.Flags().StringVar(&reportOptions.title, "title", "htflood report", "the title of the report")
+	reportCommand.Flags().StringVar(&reportOptions.percentiles, "percentiles", defaultPercentiles, "percentiles to report, comma-separated")
+}
+
+// reportAccumulator gathers everything the report shows in a single pass
+// over the responses.
+type reportAccumulator struct {
+	Overall   *Accumulator
+	Intervals map[int64]*summaryAccumulator
+	ByUrl     groupedSummaries
+	ByStep    groupedSummaries
+	Errors    map[string]*errorSummary
+}
+
+type errorSummary struct {
+	Class   string
+	Count   int
+	Example string
+}
+
+func runReport(cmd *cobra.Command, args []string) error {
+	percentiles, err := parsePercentiles(reportOptions.percentiles)
+	if err != nil {
+		return err
+	}
+
+	acc := &reportAccumulator{
+		Overall:   newAccumulator(),
+		Intervals: map[int64]*summaryAccumulator{},
+		ByUrl:     groupedSummaries{},
+		ByStep:    groupedSummaries{},
+		Errors:    map[string]*errorSummary{},
+	}
+
+	for res := range readResponses(os.Stdin) {
+		accumulateReport(acc, res)
+	}
+
+	if acc.Overall.Count == 0 {
+		return fmt.Errorf("empty data")
+	}
+
+	data := makeReportData(acc, percentiles)
+	return writeReport(reportOptions.output, data)
+}
+
+func accumulateReport(acc *reportAccumulator, res req.ResponseInfo) {
+	accumulate(acc.Overall, res)
+	// the steps table shows transactions, whose failures come from their steps
+	if res.Transaction {
+		acc.ByStep.record(res.Step, res)
+		return
+	}
+	recordInterval(acc.Intervals, res, time.Second)
+	acc.ByUrl.record(urlGroup(acc.ByUrl, res.Url), res)
+	acc.ByStep.record(res.Step, res)
+
+	if res.Error != "" {
+		class := res.ErrorClass
+		if class == "" {
+			class = errorClass(res.Error)
+		}
+		recordError(acc, class, res.Error)
+	}
+	if res.BadStatus() {
+		recordError(acc, fmt.Sprintf("status %d", res.StatusCode), res.Url)
+	}
+	for _, check := range res.Checks {
+		if !check.Passed {
+			recordError(acc, "check failed: "+check.Name, check.Reason)
+		}
+	}
+}
+
+// urlGroup returns the row of a url in the urls table. Templated urls can
+// make one url per request, so past reportMaxUrls the new urls share a row.
+func urlGroup(groups groupedSummaries, url string) string {
+	if _, ok := groups[url]; ok || len(groups) < reportMaxUrls {
+		return url
+	}
+	return "(other urls)"
+}
+
+func recordError(acc *reportAccumulator, class string, msg string) {
+	summary, ok := acc.Errors[class]
+	if !ok {
+		summary = &errorSummary{Class: class, Example: truncate(msg, reportExampleSize)}
+		acc.Errors[class] = summary
+	}
+	summary.Count++
+}
+
+// errorClass groups error messages by their root cause, which comes last in
+// wrapped error messages, as the beginning often holds request details.
+func errorClass(msg string) string {
+	parts := strings.Split(msg, ": ")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+func truncate(str string, size int) string {
+	if len(str) <= size {
+		return str
+	}
+	return str[:size] + "…"
+}
+
+type reportData struct {
+	Title        string
+	Generated    string
+	Stats        Stats
+	Duration     float64
+	Throughput   float64
+	ErrorRate    float64
+	Percentiles  []string
+	LatencyTable []namedStat
+	Latency      svgChart
+	Throughputs  svgChart
+	Histogram    svgChart
+	StatusChart  svgChart
+	StatusCodes  []statusCodeRow
+	Errors       []*errorSummary
+	Urls         []summaryRow
+	Steps        []summaryRow
+}
+
+type namedStat struct {
+	Name string
+	Stat Stat
+}
+
+type statusCodeRow struct {
+	Code    string
+	Count   int
+	Percent float64
+}
+
+type summaryRow struct {
+	Name        string
+	Count       int
+	Errors      int
+	ErrorRate   float64
+	Average     float64
+	Max         float64
+	Percentiles []float64
+}
+
+func makeReportData(acc *reportAccumulator, percentiles []float64) reportData {
+	stats := finalize(acc.Overall, percentiles)
+
+	data := reportData{
+		Title:     reportOptions.title,
+		Generated: time.Now().Format(time.RFC1123),
+		Stats:     stats,
+	}
+
+	for _, p := range percentiles {
+		data.Percentiles = append(data.Percentiles, percentileName(p))
+	}
+
+	data.LatencyTable = []namedStat{
+		{"Latency", stats.Latency},
+		{"Elapsed", stats.Elapsed},
+		{"DNS", stats.Phases.Dns.Stat},
+		{"Connect", stats.Phases.Connect.Stat},
+		{"TLS", stats.Phases.Tls.Stat},
+		{"TTFB", stats.Phases.Ttfb.Stat},
+		{"Body", stats.Phases.Body.Stat},
+	}
+	data.Latency, data.Throughputs = makeTimeCharts(acc, percentiles)
+	data.Histogram = makeHistogramChart(acc.Overall.Latency)
+	data.StatusCodes, data.StatusChart = makeStatusCodes(stats)
+	data.Errors = sortedErrors(acc.Errors)
+	data.Urls = makeSummaryRows(acc.ByUrl, percentiles)
+	data.Steps = makeSummaryRows(acc.ByStep, percentiles)
+
+	return data
+}
+
+var percentileColors = []string{"#2b8cbe", "#41ab5d", "#fd8d3c", "#e31a1c", "#88419d", "#636363"}
+
+func makeTimeCharts(acc *reportAccumulator, percentiles []float64) (svgChart, svgChart) {
+	intervals, interval := coarsenIntervals(acc.Intervals, reportMaxPoints)
+
+	keys := make([]int64, 0, len(intervals))
+	for key := range intervals {
+		keys = append(keys, key)
+	}
+	sort.Sort(int64Slice(keys))
+
+	start := float64(keys[0]) * interval.Seconds()
+	xs := make([]float64, len(keys))
+	latencies := make([]chartSeries, len(percentiles))
+	for i, p := range percentiles {
+		latencies[i] = chartSeries{Name: percentileName(p), Color: percentileColors[i%len(percentileColors)]}
+	}
+	throughput := chartSeries{Name: "requests/s", Color: "#2b8cbe"}
+	errors := chartSeries{Name: "errors/s", Color: "#e31a1c"}
+
+	for i, key := range keys {
+		summary := intervals[key]
+		xs[i] = float64(key)*interval.Seconds() - start
+		for j, p := range percentiles {
+			latencies[j].Values = append(latencies[j].Values, summary.Latency.Percentile(p))
+		}
+		throughput.Values = append(throughput.Values, float64(summary.Count)/interval.Seconds())
+		errors.Values = append(errors.Values, float64(summary.Errors)/interval.Seconds())
+	}
+
+	formatX := func(x float64) string {
+		return (time.Duration(x) * time.Second).String()
+	}
+	return newLineChart(xs, latencies, formatX), newLineChart(xs, []chartSeries{throughput, errors}, formatX)
+}
+
+// coarsenIntervals merges the per-second intervals, so that there are at
+// most maxPoints of them.
+func coarsenIntervals(intervals map[int64]*summaryAccumulator, maxPoints int) (map[int64]*summaryAccumulator, time.Duration) {
+	var first, last int64
+	started := false
+	for key := range intervals {
+		if !started || key < first {
+			first = key
+		}
+		if !started || key > last {
+			last = key
+		}
+		started = true
+	}
+
+	factor := (last-first)/int64(maxPoints) + 1
+	if factor == 1 {
+		return intervals, time.Second
+	}
+
+	coarse := map[int64]*summaryAccumulator{}
+	for key, summary := range intervals {
+		coarseKey := key / factor
+		acc, ok := coarse[coarseKey]
+		if !ok {
+			acc = newSummaryAccumulator()
+			coarse[coarseKey] = acc
+		}
+		acc.merge(summary)
+	}
+	return coarse, time.Duration(factor) * time.Second
+}
+
+// makeHistogramChart bins the latencies on a logarithmic scale, as latency
+// distributions usually have a long tail.
+func makeHistogramChart(hist *req.Histogram) svgChart {
+	lo := 0.0
+	hist.Buckets(func(value float64, count int64) {
+		if lo == 0 && value > 0 {
+			lo = value
+		}
+	})
+	lo = math.Max(lo, 1.0/req.DurationScale)
+	hi := math.Max(hist.Max(), lo*1.01)
+	ratio := math.Log(hi / lo)
+
+	counts := make([]float64, reportHistBins)
+	hist.Buckets(func(value float64, count int64) {
+		bin := 0
+		if value > lo {
+			bin = int(math.Log(value/lo) / ratio * reportHistBins)
+		}
+		if bin >= reportHistBins {
+			bin = reportHistBins - 1
+		}
+		counts[bin] += float64(count)
+	})
+
+	labels := make([]string, reportHistBins)
+	for i := range labels {
+		labels[i] = formatNumber(lo*math.Exp(ratio*float64(i)/reportHistBins)) + "ms"
+	}
+
+	return newBarChart(labels, counts, "#2b8cbe")
+}
+
+func makeStatusCodes(stats Stats) ([]statusCodeRow, svgChart) {
+	codes := make([]string, 0, len(stats.StatusCodes))
+	for code := range stats.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	rows := make([]statusCodeRow, 0, len(codes))
+	counts := make([]float64, 0, len(codes))
+	for _, code := range codes {
+		count := stats.StatusCodes[code]
+		rows = append(rows, statusCodeRow{
+			Code:    code,
+			Count:   count,
+			Percent: 100 * float64(count) / float64(stats.Count),
+		})
+		counts = append(counts, float64(count))
+	}
+
+	return rows, newBarChart(codes, counts, "#41ab5d")
+}
+
+func sortedErrors(errors map[string]*errorSummary) []*errorSummary {
+	sorted := make([]*errorSummary, 0, len(errors))
+	for _, e := range errors {
+		sorted = append(sorted, e)
+	}
+	sort.Sort(errorsByCount(sorted))
+	if len(sorted) > reportMaxErrors {
+		sorted = sorted[:reportMaxErrors]
+	}
+	return sorted
+}
+
+type errorsByCount []*errorSummary
+
+func (s errorsByCount) Len() int      { return len(s) }
+func (s errorsByCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s errorsByCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Class < s[j].Class
+}
+
+func makeSummaryRows(groups groupedSummaries, percentiles []float64) []summaryRow {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := make([]summaryRow, 0, len(names))
+	for _, name := range names {
+		summary := groups[name]
+		if name == "" {
+			name = "-"
+		}
+		row := summaryRow{
+			Name:      name,
+			Count:     summary.Count,
+			Errors:    summary.Errors,
+			ErrorRate: 100 * float64(summary.Errors) / float64(summary.Count),
+			Average:   summary.Latency.Mean(),
+			Max:       summary.Latency.Max(),
+		}
+		for _, p := range percentiles {
+			row.Percentiles = append(row.Percentiles, summary.Latency.Percentile(p))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func writeReport(filename string, data reportData) error {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"num": formatNumber,
+		"pct": func(value float64) string { return fmt.Sprintf("%.2f%%", value) },
+		"summaries": func(percentiles []string, rows []summaryRow) map[string]interface{} {
+			return map[string]interface{}{"Percentiles": percentiles, "Rows": rows}
+		},
+	}).Parse(reportTemplate)
+	if err != nil {
+		return fmt.Errorf("Failed to parse report template: %v", err)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to create report file %v: %v", filename, err)
+	}
+	defer file.Close()
+
+	if err = tmpl.Execute(file, data); err != nil {
+		return fmt.Errorf("Failed to write report to %v: %v", filename, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Report written to %v\n", filename)
+	return nil
+}
@@ -0,0 +1,106 @@
+package commands
+
+// reportTemplate is self-contained: charts are inline svg, and styles are
+// embedded, so that the report can be attached and opened anywhere.
+const reportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; color: #252525; margin: 2em auto; max-width: 900px; }
+h1 { margin-bottom: 0; }
+h2 { border-bottom: 1px solid #d9d9d9; padding-bottom: 0.2em; margin-top: 2em; }
+.generated { color: #737373; }
+.figures { display: flex; flex-wrap: wrap; }
+.figure { margin: 1em 2em 0 0; }
+.figure .value { font-size: 1.6em; font-weight: bold; }
+.figure .label { color: #737373; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { text-align: right; padding: 0.3em 0.6em; border-bottom: 1px solid #f0f0f0; }
+th:first-child, td:first-child { text-align: left; }
+td.message { text-align: left; font-family: monospace; font-size: 0.85em; word-break: break-all; }
+svg text { font-size: 11px; fill: #525252; }
+.legend span { margin-right: 1.5em; }
+.legend i { display: inline-block; width: 1em; height: 0.3em; margin-right: 0.4em; vertical-align: middle; }
+</style>
+</head>
+<body>
+
+{{ define "chart" }}
+<svg width="{{ .Width }}" height="{{ .Height }}" viewBox="0 0 {{ .Width }} {{ .Height }}">
+  {{ range .YLabels }}<line x1="{{ $.Left }}" x2="{{ $.Right }}" y1="{{ .Y }}" y2="{{ .Y }}" stroke="#f0f0f0" transform="translate(0,-4)"/>
+  <text x="{{ .X }}" y="{{ .Y }}" text-anchor="end">{{ .Text }}</text>{{ end }}
+  {{ range .XLabels }}<text x="{{ .X }}" y="{{ .Y }}" text-anchor="middle">{{ .Text }}</text>{{ end }}
+  <line x1="{{ .Left }}" x2="{{ .Right }}" y1="{{ .Bottom }}" y2="{{ .Bottom }}" stroke="#969696"/>
+  {{ range .Bars }}<rect x="{{ .X }}" y="{{ .Y }}" width="{{ .Width }}" height="{{ .Height }}" fill="{{ .Color }}"><title>{{ .Title }}</title></rect>{{ end }}
+  {{ range .Series }}<polyline points="{{ .Points }}" fill="none" stroke="{{ .Color }}" stroke-width="1.5"><title>{{ .Name }}</title></polyline>{{ end }}
+</svg>
+{{ if .Series }}<div class="legend">{{ range .Series }}<span><i style="background: {{ .Color }}"></i>{{ .Name }}</span>{{ end }}</div>{{ end }}
+{{ end }}
+
+{{ define "summaries" }}
+<table>
+  <tr><th></th><th>Count</th><th>Errors</th><th>Error rate</th><th>Average</th>{{ range $.Percentiles }}<th>{{ . }}</th>{{ end }}<th>Max</th></tr>
+  {{ range .Rows }}<tr><td>{{ .Name }}</td><td>{{ .Count }}</td><td>{{ .Errors }}</td><td>{{ pct .ErrorRate }}</td><td>{{ num .Average }}</td>{{ range .Percentiles }}<td>{{ num . }}</td>{{ end }}<td>{{ num .Max }}</td></tr>
+  {{ end }}
+</table>
+{{ end }}
+
+<h1>{{ .Title }}</h1>
+<div class="generated">Generated {{ .Generated }}</div>
+
+<div class="figures">
+  <div class="figure"><div class="value">{{ .Stats.Count }}</div><div class="label">requests</div></div>
+  <div class="figure"><div class="value">{{ num .Stats.Duration }}s</div><div class="label">duration</div></div>
+  <div class="figure"><div class="value">{{ num .Stats.Throughput }}</div><div class="label">requests/s</div></div>
+  <div class="figure"><div class="value">{{ pct .Stats.ErrorRate }}</div><div class="label">errors</div></div>
+  <div class="figure"><div class="value">{{ num .Stats.Latency.Average }}ms</div><div class="label">average latency</div></div>
+  <div class="figure"><div class="value">{{ num .Stats.Latency.Max }}ms</div><div class="label">max latency</div></div>
+</div>
+
+<h2>Latency</h2>
+<table>
+  <tr><th></th><th>Min</th><th>Average</th><th>StdDev</th>{{ range .Percentiles }}<th>{{ . }}</th>{{ end }}<th>Max</th></tr>
+  {{ $percentiles := .Percentiles }}
+  {{ range .LatencyTable }}{{ $stat := .Stat }}<tr><td>{{ .Name }}</td><td>{{ num $stat.Min }}</td><td>{{ num $stat.Average }}</td><td>{{ num $stat.StdDev }}</td>{{ range $percentiles }}<td>{{ num (index $stat.Percentiles .) }}</td>{{ end }}<td>{{ num $stat.Max }}</td></tr>
+  {{ end }}
+</table>
+
+<h3>Latency over time (ms)</h3>
+{{ template "chart" .Latency }}
+
+<h3>Latency distribution</h3>
+{{ template "chart" .Histogram }}
+
+<h2>Throughput</h2>
+{{ template "chart" .Throughputs }}
+
+<h2>Status codes</h2>
+{{ template "chart" .StatusChart }}
+<table>
+  <tr><th>Status</th><th>Count</th><th>Share</th></tr>
+  {{ range .StatusCodes }}<tr><td>{{ .Code }}</td><td>{{ .Count }}</td><td>{{ pct .Percent }}</td></tr>
+  {{ end }}
+</table>
+
+<h2>Errors</h2>
+{{ if .Errors }}
+<table>
+  <tr><th>Error</th><th>Count</th><th>Example</th></tr>
+  {{ range .Errors }}<tr><td>{{ .Class }}</td><td>{{ .Count }}</td><td class="message">{{ .Example }}</td></tr>
+  {{ end }}
+</table>
+{{ else }}
+<p>No errors.</p>
+{{ end }}
+
+<h2>Urls</h2>
+{{ template "summaries" (summaries .Percentiles .Urls) }}
+
+<h2>Steps</h2>
+{{ template "summaries" (summaries .Percentiles .Steps) }}
+
+</body>
+</html>
+`
@@ -1,12 +1,17 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vincentcr/htflood/req"
@@ -23,6 +28,9 @@ var reqCommand = &cobra.Command{
 var reqOptions struct {
 	count       uint
 	concurrency uint
+	duration    time.Duration
+	rate        string
+	maxInFlight uint
 	auth        string
 	authScheme  string
 	botList     string
@@ -30,6 +38,8 @@ var reqOptions struct {
 	botApiKey   string
 	debug       bool
 	insecure    bool
+	cookies     bool
+	gracePeriod time.Duration
 	pretty      bool
 }
 
@@ -49,8 +59,11 @@ var argPatterns struct {
 }
 
 func init() {
-	reqCommand.Flags().UintVar(&reqOptions.count, "count", 1, "count")
+	reqCommand.Flags().UintVar(&reqOptions.count, "count", 0, "number of requests per worker (default: 1, or unlimited with --duration)")
 	reqCommand.Flags().UintVar(&reqOptions.concurrency, "concurrency", 1, "count")
+	reqCommand.Flags().DurationVar(&reqOptions.duration, "duration", 0, "keep sending requests for this long (e.g. 10m)")
+	reqCommand.Flags().StringVar(&reqOptions.rate, "rate", "", "send requests at a constant rate (e.g. 500/s), regardless of response times")
+	reqCommand.Flags().UintVar(&reqOptions.maxInFlight, "max-in-flight", 0, "with --rate, the most requests waiting for their response at once (default: 1000)")
 	reqCommand.Flags().StringVar(&reqOptions.auth, "auth", "", "auth credentials (username:password)")
 	reqCommand.Flags().StringVar(&reqOptions.authScheme, "auth-scheme", string(req.AuthSchemeBasic), "the auth scheme to use (default: basic)")
 	reqCommand.Flags().StringVar(&reqOptions.botList, "bots", "", "bot list, comma-separated")
@@ -59,6 +72,8 @@ func init() {
 	reqCommand.Flags().BoolVar(&reqOptions.debug, "debug", false, "enables debug output")
 	reqCommand.Flags().BoolVar(&reqOptions.insecure, "insecure", false, "don't verify TLS certifcates")
 	reqCommand.Flags().BoolVar(&reqOptions.pretty, "pretty", false, "formatted output")
+	reqCommand.Flags().BoolVar(&reqOptions.cookies, "cookies", false, "keep the cookies set by responses")
+	reqCommand.Flags().DurationVar(&reqOptions.gracePeriod, "grace-period", 0, "on interrupt, how long to wait for the requests in flight (default: 10s)")
 
 	argPatterns.method = regexp.MustCompile("^[A-Z]+$")
 	argPatterns.url = regexp.MustCompile("^https?://+")
@@ -74,7 +89,35 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	return req.Execute(scenario, os.Stdout)
+	ctx, stop := stopOnSignal()
+	defer stop()
+
+	return req.Execute(ctx, scenario, os.Stdout)
+}
+
+// stopOnSignal stops the run gracefully on the first SIGINT or SIGTERM, and
+// quits right away on the second.
+func stopOnSignal() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		log.Printf("Stopping, waiting for the requests in flight (interrupt again to quit)\n")
+		cancel()
+		if _, ok := <-signals; ok {
+			os.Exit(130)
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+	}
 }
 
 func parseScenarioFromInput(args []string) (req.RequestScenario, error) {
@@ -130,10 +173,20 @@ func parseScenarioFromCommandLine(args []string, scenario **req.RequestScenario)
 	}
 
 	if nil == *scenario {
+		var rate req.Rate
+		if reqOptions.rate != "" {
+			rate, err = req.ParseRate(reqOptions.rate)
+			if err != nil {
+				return err
+			}
+		}
 
 		tmpl := req.RequestTemplate{
 			Count:       reqOptions.count,
 			Concurrency: reqOptions.concurrency,
+			Duration:    req.Duration(reqOptions.duration),
+			Rate:        rate,
+			MaxInFlight: reqOptions.maxInFlight,
 			Auth:        reqOptions.auth,
 			AuthScheme:  req.AuthScheme(reqOptions.authScheme),
 		}
@@ -148,9 +201,11 @@ func parseScenarioFromCommandLine(args []string, scenario **req.RequestScenario)
 			Bots:     bots,
 			Requests: []req.RequestTemplate{tmpl},
 			Options: req.Options{
-				Debug:    reqOptions.debug,
-				Insecure: reqOptions.insecure,
-				Pretty:   reqOptions.pretty,
+				Debug:       reqOptions.debug,
+				Insecure:    reqOptions.insecure,
+				Pretty:      reqOptions.pretty,
+				Cookies:     reqOptions.cookies,
+				GracePeriod: req.Duration(reqOptions.gracePeriod),
 			},
 		}
 
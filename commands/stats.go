@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"os"
-	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vincentcr/htflood/req"
@@ -19,50 +22,205 @@ var statsCommand = &cobra.Command{
 	Run:   checkedRun(runStats),
 }
 
+var statsOptions struct {
+	percentiles string
+	interval    time.Duration
+}
+
+const defaultPercentiles = "50,90,99,99.9,99.99"
+
+func init() {
+	statsCommand.Flags().StringVar(&statsOptions.percentiles, "percentiles", defaultPercentiles, "percentiles to report, comma-separated")
+	statsCommand.Flags().DurationVar(&statsOptions.interval, "interval", 0, "output one row of stats per interval (e.g. 1s), instead of aggregating the whole run")
+}
+
 func runStats(cmd *cobra.Command, args []string) error {
+	percentiles, err := parsePercentiles(statsOptions.percentiles)
+	if err != nil {
+		return err
+	}
+
+	if statsOptions.interval > 0 {
+		intervals := accumulateIntervals(readResponses(os.Stdin), statsOptions.interval)
+		if len(intervals) == 0 {
+			return fmt.Errorf("empty data")
+		}
+		printIntervals(finalizeIntervals(intervals, statsOptions.interval, percentiles))
+		return nil
+	}
 
-	acc := Accumulator{}
-	acc.Responses = make([]req.ResponseInfo, 0)
+	acc := newAccumulator()
 
-	for res := range readResponses() {
-		accumulate(&acc, res)
+	for res := range readResponses(os.Stdin) {
+		accumulate(acc, res)
 	}
 
-	stats := finalize(&acc)
+	if acc.Count == 0 {
+		return fmt.Errorf("empty data")
+	}
+
+	stats := finalize(acc, percentiles)
 	print(stats)
 
 	return nil
 }
 
 type Stat struct {
-	Average float64
-	StdDev  float64
-	Q95     float64
-	Q5      float64
-	Total   float64
+	Min         float64
+	Max         float64
+	Average     float64
+	StdDev      float64
+	Q95         float64
+	Q5          float64
+	Percentiles map[string]float64
+	Total       float64
 }
 
 const Precision = 4
 
 type Stats struct {
-	Elapsed     Stat
-	Transfer    Stat
-	Count       int
-	StatusCodes map[string]int
+	Elapsed      Stat
+	Latency      Stat
+	Phases       PhaseStats
+	Transfer     Stat
+	Count        int
+	Errors       int
+	ErrorRate    float64 // percentage of requests
+	Timeouts     int     `json:",omitempty"`
+	Duration     float64 // seconds
+	Throughput   float64 // requests per second
+	ConnReused   int
+	StatusCodes  map[string]int
+	Checks       map[string]*CheckStat       `json:",omitempty"`
+	Transactions map[string]*TransactionStat `json:",omitempty"`
+	Retries      *RetryStats                 `json:",omitempty"`
 }
 
+// CheckStat is the pass rate of a response check.
+type CheckStat struct {
+	Passed   int
+	Failed   int
+	PassRate float64 // percentage of responses
+}
+
+// RetryStats tells apart the requests which succeeded on their first attempt
+// from those which only succeeded after retries, among the requests with a
+// retry policy.
+type RetryStats struct {
+	Count               int
+	Attempts            int
+	Retried             int     // requests which took more than one attempt
+	FirstTrySuccessRate float64 // percentage of requests
+	EventualSuccessRate float64 // percentage of requests, after their retries
+}
+
+// TransactionStat is the duration of the executions of a group of steps,
+// from the start of its first request to the end of its last.
+type TransactionStat struct {
+	Count     int
+	Errors    int
+	ErrorRate float64 // percentage of executions
+	Elapsed   Stat
+}
+
+// PhaseStats breaks down the elapsed time, to tell network, TLS and server
+// time apart. Each phase only counts the requests which went through it:
+// requests made on a reused connection don't resolve, connect or handshake,
+// and plain http requests don't handshake.
+type PhaseStats struct {
+	Dns     PhaseStat
+	Connect PhaseStat
+	Tls     PhaseStat
+	Ttfb    PhaseStat
+	Body    PhaseStat
+}
+
+// PhaseStat is the time spent in a phase by the Count requests which went
+// through it.
+type PhaseStat struct {
+	Count int
+	Stat
+}
+
+// Accumulator aggregates responses as they are read, in constant memory.
 type Accumulator struct {
-	Stats     Stats
-	Responses []req.ResponseInfo
+	Count        int
+	Errors       int
+	Timeouts     int
+	Started      float64 // timestamp of the first request
+	Ended        float64 // timestamp at which the last response completed
+	ConnReused   int
+	StatusCodes  map[string]int
+	Checks       map[string]*CheckStat
+	Transactions map[string]*TransactionAccumulator
+	Retries      RetryAccumulator
+	Elapsed      *req.Histogram
+	Latency      *req.Histogram
+	Phases       PhaseHistograms
+	Transfer     *req.Histogram
+}
+
+type RetryAccumulator struct {
+	Count             int
+	Attempts          int
+	Retried           int
+	FirstTrySuccesses int
+	Successes         int
+}
+
+type TransactionAccumulator struct {
+	Count   int
+	Errors  int
+	Elapsed *req.Histogram
+}
+
+type PhaseHistograms struct {
+	Dns     *req.Histogram
+	Connect *req.Histogram
+	Tls     *req.Histogram
+	Ttfb    *req.Histogram
+	Body    *req.Histogram
 }
 
-type statAccessor func(res req.ResponseInfo) float64
+func newAccumulator() *Accumulator {
+	return &Accumulator{
+		StatusCodes:  map[string]int{},
+		Checks:       map[string]*CheckStat{},
+		Transactions: map[string]*TransactionAccumulator{},
+		Elapsed:      req.NewHistogram(req.DurationScale),
+		Latency:      req.NewHistogram(req.DurationScale),
+		Phases: PhaseHistograms{
+			Dns:     req.NewHistogram(req.DurationScale),
+			Connect: req.NewHistogram(req.DurationScale),
+			Tls:     req.NewHistogram(req.DurationScale),
+			Ttfb:    req.NewHistogram(req.DurationScale),
+			Body:    req.NewHistogram(req.DurationScale),
+		},
+		Transfer: req.NewHistogram(1),
+	}
+}
 
-func readResponses() chan req.ResponseInfo {
+func parsePercentiles(str string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Split(str, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(field, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile '%v'", field)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+func readResponses(reader io.Reader) chan req.ResponseInfo {
 	out := make(chan req.ResponseInfo)
 
 	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
+		scanner := bufio.NewScanner(reader)
 
 		for scanner.Scan() {
 			line := scanner.Text()
@@ -75,7 +233,7 @@ func readResponses() chan req.ResponseInfo {
 		}
 
 		if err := scanner.Err(); err != nil {
-			fatal(fmt.Errorf("Error reading stdin: %v", err))
+			fatal(fmt.Errorf("Error reading input: %v", err))
 		}
 
 		close(out)
@@ -91,94 +249,191 @@ func parse(line string) (req.ResponseInfo, error) {
 }
 
 func accumulate(acc *Accumulator, res req.ResponseInfo) {
-	acc.Responses = append(acc.Responses, res)
-	accumulateStat(&acc.Stats.Elapsed, res.Elapsed)
-	accumulateStat(&acc.Stats.Transfer, float64(res.Length))
+	// transactions aren't requests, and only count for their own stats
+	if res.Transaction {
+		accumulateTransaction(acc, res)
+		return
+	}
+
+	ended := res.Timestamp + res.EffectiveLatency()/1000
+	if acc.Count == 0 || res.Timestamp < acc.Started {
+		acc.Started = res.Timestamp
+	}
+	if ended > acc.Ended {
+		acc.Ended = ended
+	}
+
+	acc.Count++
+	acc.StatusCodes[fmt.Sprintf("%d", res.StatusCode)]++
+	if res.Failed() {
+		acc.Errors++
+	}
+	if strings.HasSuffix(res.ErrorClass, "timeout") {
+		acc.Timeouts++
+	}
+	if res.ConnReused {
+		acc.ConnReused++
+	}
+	for _, check := range res.Checks {
+		accumulateCheck(acc, check)
+	}
+	if res.Attempts > 0 {
+		accumulateRetries(&acc.Retries, res)
+	}
+
+	acc.Elapsed.Record(res.Elapsed)
+	acc.Latency.Record(res.EffectiveLatency())
+	recordPhase(acc.Phases.Dns, res.DnsMs)
+	recordPhase(acc.Phases.Connect, res.ConnectMs)
+	recordPhase(acc.Phases.Tls, res.TlsMs)
+	recordPhase(acc.Phases.Ttfb, res.TtfbMs)
+	recordPhase(acc.Phases.Body, res.BodyMs)
+	acc.Transfer.Record(float64(res.Length))
 }
 
-func accumulateStat(stat *Stat, value float64) {
-	stat.Total += value
+// recordPhase records the time of a phase, if the request went through it:
+// responses report 0 for the phases they skipped.
+func recordPhase(hist *req.Histogram, ms float64) {
+	if ms > 0 {
+		hist.Record(ms)
+	}
 }
 
-func finalize(acc *Accumulator) Stats {
-	stats := acc.Stats
-	stats.Count = len(acc.Responses)
+func accumulateCheck(acc *Accumulator, check req.CheckResult) {
+	stat, ok := acc.Checks[check.Name]
+	if !ok {
+		stat = &CheckStat{}
+		acc.Checks[check.Name] = stat
+	}
+	if check.Passed {
+		stat.Passed++
+	} else {
+		stat.Failed++
+	}
+}
 
-	if stats.Count == 0 {
-		fatal(fmt.Errorf("empty data"))
+func accumulateRetries(acc *RetryAccumulator, res req.ResponseInfo) {
+	acc.Count++
+	acc.Attempts += int(res.Attempts)
+	if res.Attempts > 1 {
+		acc.Retried++
 	}
+	if !res.Failed() {
+		acc.Successes++
+		if res.Attempts == 1 {
+			acc.FirstTrySuccesses++
+		}
+	}
+}
 
-	finalizeStat(&stats.Elapsed, acc.Responses, func(res req.ResponseInfo) float64 {
-		return res.Elapsed
-	})
-	finalizeStat(&stats.Transfer, acc.Responses, func(res req.ResponseInfo) float64 {
-		return float64(res.Length)
-	})
-	stats.StatusCodes = calcStatusCodes(acc)
+func accumulateTransaction(acc *Accumulator, res req.ResponseInfo) {
+	stat, ok := acc.Transactions[res.Step]
+	if !ok {
+		stat = &TransactionAccumulator{Elapsed: req.NewHistogram(req.DurationScale)}
+		acc.Transactions[res.Step] = stat
+	}
+	stat.Count++
+	if res.Failed() {
+		stat.Errors++
+	}
+	stat.Elapsed.Record(res.Elapsed)
+}
 
-	return stats
+func finalize(acc *Accumulator, percentiles []float64) Stats {
+	duration := acc.Ended - acc.Started
+	throughput := 0.0
+	if duration > 0 {
+		throughput = float64(acc.Count) / duration
+	}
+
+	return Stats{
+		Elapsed: finalizeStat(acc.Elapsed, percentiles),
+		Latency: finalizeStat(acc.Latency, percentiles),
+		Phases: PhaseStats{
+			Dns:     finalizePhase(acc.Phases.Dns, percentiles),
+			Connect: finalizePhase(acc.Phases.Connect, percentiles),
+			Tls:     finalizePhase(acc.Phases.Tls, percentiles),
+			Ttfb:    finalizePhase(acc.Phases.Ttfb, percentiles),
+			Body:    finalizePhase(acc.Phases.Body, percentiles),
+		},
+		Transfer:     finalizeStat(acc.Transfer, percentiles),
+		Count:        acc.Count,
+		Errors:       acc.Errors,
+		Timeouts:     acc.Timeouts,
+		ErrorRate:    round(100*float64(acc.Errors)/float64(acc.Count), Precision),
+		Duration:     round(duration, 3),
+		Throughput:   round(throughput, Precision),
+		ConnReused:   acc.ConnReused,
+		StatusCodes:  acc.StatusCodes,
+		Checks:       finalizeChecks(acc.Checks),
+		Transactions: finalizeTransactions(acc.Transactions, percentiles),
+		Retries:      finalizeRetries(acc.Retries),
+	}
 }
 
-func calcStatusCodes(acc *Accumulator) map[string]int {
-	statusCodes := map[string]int{}
+func finalizeRetries(acc RetryAccumulator) *RetryStats {
+	if acc.Count == 0 {
+		return nil
+	}
+	return &RetryStats{
+		Count:               acc.Count,
+		Attempts:            acc.Attempts,
+		Retried:             acc.Retried,
+		FirstTrySuccessRate: round(100*float64(acc.FirstTrySuccesses)/float64(acc.Count), Precision),
+		EventualSuccessRate: round(100*float64(acc.Successes)/float64(acc.Count), Precision),
+	}
+}
 
-	for _, res := range acc.Responses {
-		statusCode := fmt.Sprintf("%d", res.StatusCode)
-		count, ok := statusCodes[statusCode]
-		if !ok {
-			count = 0
+func finalizeTransactions(transactions map[string]*TransactionAccumulator, percentiles []float64) map[string]*TransactionStat {
+	stats := map[string]*TransactionStat{}
+	for name, acc := range transactions {
+		stats[name] = &TransactionStat{
+			Count:     acc.Count,
+			Errors:    acc.Errors,
+			ErrorRate: round(100*float64(acc.Errors)/float64(acc.Count), Precision),
+			Elapsed:   finalizeStat(acc.Elapsed, percentiles),
 		}
-		statusCodes[statusCode] = count + 1
 	}
-	return statusCodes
+	return stats
 }
 
-func finalizeStat(stat *Stat, responses []req.ResponseInfo, accessor statAccessor) {
-	stat.Average = stat.Total / float64(len(responses))
-	values := sortedValues(responses, accessor)
-	for _, value := range values {
-		stat.StdDev = math.Abs(stat.Average - value)
+func finalizeChecks(checks map[string]*CheckStat) map[string]*CheckStat {
+	for _, stat := range checks {
+		stat.PassRate = round(100*float64(stat.Passed)/float64(stat.Passed+stat.Failed), Precision)
 	}
-	stat.Total = round(stat.Total, Precision)
-	stat.Average = round(stat.Average, Precision)
-	stat.StdDev = round(math.Sqrt(stat.StdDev), Precision)
-	stat.Q5 = round(percentile(5, values), Precision)
-	stat.Q95 = round(percentile(95, values), Precision)
+	return checks
 }
 
-func sortedValues(responses []req.ResponseInfo, accessor statAccessor) []float64 {
-	values := make([]float64, 0, len(responses))
+func finalizeStat(hist *req.Histogram, percentiles []float64) Stat {
+	stat := Stat{
+		Min:         round(hist.Min(), Precision),
+		Max:         round(hist.Max(), Precision),
+		Average:     round(hist.Mean(), Precision),
+		StdDev:      round(hist.StdDev(), Precision),
+		Q95:         round(hist.Percentile(95), Precision),
+		Q5:          round(hist.Percentile(5), Precision),
+		Total:       round(hist.Total(), Precision),
+		Percentiles: map[string]float64{},
+	}
 
-	for _, res := range responses {
-		value := accessor(res)
-		values = append(values, value)
+	for _, p := range percentiles {
+		stat.Percentiles[percentileName(p)] = round(hist.Percentile(p), Precision)
 	}
 
-	sort.Float64s(values)
-	return values
+	return stat
 }
 
-func round(value float64, precision int) float64 {
-	var mult = math.Pow10(precision)
-	return float64(int(value*mult+0.5)) / mult
+func finalizePhase(hist *req.Histogram, percentiles []float64) PhaseStat {
+	return PhaseStat{Count: int(hist.Count()), Stat: finalizeStat(hist, percentiles)}
 }
 
-func percentile(p float64, values []float64) float64 {
-	var result float64
-
-	n := len(values)
-	rank := (p / 100.0) * float64(n)
-	idx := int(rank)
-	val := values[idx]
-	if idx == n-1 {
-		result = val
-	} else {
-		nextVal := values[idx+1]
-		weight := rank - float64(idx)
-		result = val + (nextVal-val)*weight
-	}
+func percentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
 
-	return result
+func round(value float64, precision int) float64 {
+	var mult = math.Pow10(precision)
+	return math.Floor(value*mult+0.5) / mult
 }
 
 func print(stats Stats) {
@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/vincentcr/htflood/req"
+)
+
+func TestPhaseStatsOnlyCountTheirRequests(t *testing.T) {
+	acc := newAccumulator()
+	// a new connection, then requests on the same connection
+	accumulate(acc, req.ResponseInfo{Elapsed: 30, DnsMs: 4, ConnectMs: 10, TlsMs: 12, TtfbMs: 20, BodyMs: 2})
+	accumulate(acc, req.ResponseInfo{Elapsed: 10, TtfbMs: 8, BodyMs: 2, ConnReused: true})
+	accumulate(acc, req.ResponseInfo{Elapsed: 12, TtfbMs: 10, BodyMs: 2, ConnReused: true})
+
+	stats := finalize(acc, []float64{50})
+	tests := []struct {
+		name    string
+		phase   PhaseStat
+		count   int
+		average float64
+	}{
+		{"dns", stats.Phases.Dns, 1, 4},
+		{"connect", stats.Phases.Connect, 1, 10},
+		{"tls", stats.Phases.Tls, 1, 12},
+		{"ttfb", stats.Phases.Ttfb, 3, 38.0 / 3},
+		{"body", stats.Phases.Body, 3, 2},
+	}
+	for _, test := range tests {
+		if test.phase.Count != test.count || test.phase.Average != round(test.average, Precision) {
+			t.Errorf("%v: expected %v requests averaging %v, got %v averaging %v", test.name, test.count, test.average,
+				test.phase.Count, test.phase.Average)
+		}
+	}
+}
+
+func TestStatQuantilesMatchPercentiles(t *testing.T) {
+	acc := newAccumulator()
+	for i := 1; i <= 100; i++ {
+		accumulate(acc, req.ResponseInfo{Elapsed: float64(i)})
+	}
+
+	stats := finalize(acc, []float64{5, 95})
+	if stats.Elapsed.Q5 == 0 || stats.Elapsed.Q5 != stats.Elapsed.Percentiles["p5"] {
+		t.Errorf("expected q5 to be p5 %v, got %v", stats.Elapsed.Percentiles["p5"], stats.Elapsed.Q5)
+	}
+	if stats.Elapsed.Q95 == 0 || stats.Elapsed.Q95 != stats.Elapsed.Percentiles["p95"] {
+		t.Errorf("expected q95 to be p95 %v, got %v", stats.Elapsed.Percentiles["p95"], stats.Elapsed.Q95)
+	}
+}
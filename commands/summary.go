@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/vincentcr/htflood/req"
+)
+
+// summaryAccumulator keeps the main figures of a group of responses, such as
+// the requests of an interval or of a url, with a small latency histogram so
+// that there can be many of them.
+type summaryAccumulator struct {
+	Count       int
+	Errors      int
+	StatusCodes map[string]int
+	Latency     *req.Histogram
+}
+
+// summary histograms keep 2 significant digits, as there can be many of them
+const summaryPrecision = 2
+
+func newSummaryAccumulator() *summaryAccumulator {
+	return &summaryAccumulator{
+		StatusCodes: map[string]int{},
+		Latency:     req.NewHistogramWithPrecision(req.DurationScale, summaryPrecision),
+	}
+}
+
+func (acc *summaryAccumulator) record(res req.ResponseInfo) {
+	acc.Count++
+	acc.StatusCodes[fmt.Sprintf("%d", res.StatusCode)]++
+	if res.Failed() {
+		acc.Errors++
+	}
+	acc.Latency.Record(res.EffectiveLatency())
+}
+
+func (acc *summaryAccumulator) merge(other *summaryAccumulator) {
+	acc.Count += other.Count
+	acc.Errors += other.Errors
+	for code, count := range other.StatusCodes {
+		acc.StatusCodes[code] += count
+	}
+	acc.Latency.Merge(other.Latency)
+}
+
+// groupedSummaries accumulates responses by key, e.g. by url.
+type groupedSummaries map[string]*summaryAccumulator
+
+func (groups groupedSummaries) record(key string, res req.ResponseInfo) {
+	acc, ok := groups[key]
+	if !ok {
+		acc = newSummaryAccumulator()
+		groups[key] = acc
+	}
+	acc.record(res)
+}
@@ -0,0 +1,243 @@
+package req
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+type ResponseCaptureSource string
+
+const (
+	ResponseCaptureHeader ResponseCaptureSource = "header"
+	ResponseCaptureBody   ResponseCaptureSource = "body"
+	ResponseCaptureRegex  ResponseCaptureSource = "regex"
+	ResponseCaptureXpath  ResponseCaptureSource = "xpath"
+	ResponseCaptureCss    ResponseCaptureSource = "css"
+	ResponseCaptureStatus ResponseCaptureSource = "status"
+	ResponseCaptureCookie ResponseCaptureSource = "cookie"
+	ResponseCaptureUrl    ResponseCaptureSource = "url"
+)
+
+// ResponseCapture captures a value of a response into the variable Name.
+// Depending on its Source, Expression is:
+//
+//   - header, cookie: the name of the header or cookie, by default Name
+//   - body: an expression into the json body, in the given Syntax
+//   - regex: a regular expression over the raw body. The first group is
+//     captured, or the whole match if there is none
+//   - xpath: an xpath expression into the xml body
+//   - css: a css selector into the html body. The text of the first match is
+//     captured, or its Attribute if set
+//
+// status and url capture the status code and the final url of the response.
+type ResponseCapture struct {
+	Source     ResponseCaptureSource
+	Name       string
+	Expression string
+	Syntax     ExpressionSyntax
+	Attribute  string
+	compiled   interface{} // the expression compiled with the template, unless templated
+}
+
+// ExpressionSyntax is the syntax of an expression into a json body. When
+// empty, expressions starting with '$' are jsonpath, and others are simple.
+type ExpressionSyntax string
+
+const (
+	// ExpressionSimple is a dotted path, e.g. "data.items[0].id"
+	ExpressionSimple ExpressionSyntax = "simple"
+	// ExpressionJsonPath is a jsonpath, e.g. "$.items[?(@.price < 10)].id"
+	ExpressionJsonPath ExpressionSyntax = "jsonpath"
+)
+
+func (capture ResponseCapture) needsBody() bool {
+	switch capture.Source {
+	case ResponseCaptureBody, ResponseCaptureRegex, ResponseCaptureXpath, ResponseCaptureCss:
+		return true
+	}
+	return false
+}
+
+// validate rejects a capture whose source is unknown, rather than letting it
+// fail on every response.
+func (capture ResponseCapture) validate() error {
+	switch capture.Source {
+	case ResponseCaptureHeader, ResponseCaptureBody, ResponseCaptureRegex, ResponseCaptureXpath,
+		ResponseCaptureCss, ResponseCaptureStatus, ResponseCaptureCookie, ResponseCaptureUrl:
+		return nil
+	}
+	return fmt.Errorf("unknown source '%v' of capture %v", capture.Source, capture.Name)
+}
+
+// compile compiles the expression of a capture, according to its source.
+// Header, cookie, status and url captures have nothing to compile.
+func (capture ResponseCapture) compile() (interface{}, error) {
+	var compiled interface{}
+	var err error
+	switch capture.Source {
+	case ResponseCaptureBody:
+		compiled, err = compileBodyQuery(capture.Expression, capture.Syntax)
+	case ResponseCaptureRegex:
+		compiled, err = compileRegexp(capture.Expression)
+	case ResponseCaptureXpath:
+		compiled, err = compileXpath(capture.Expression)
+	case ResponseCaptureCss:
+		compiled, err = compileCss(capture.Expression)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// expression returns the compiled expression of the capture, or compiles it
+// when it was templated.
+func (capture ResponseCapture) expression() (interface{}, error) {
+	if capture.compiled != nil {
+		return capture.compiled, nil
+	}
+	return capture.compile()
+}
+
+// key is the name of the header or cookie to capture.
+func (capture ResponseCapture) key() string {
+	if capture.Expression != "" {
+		return capture.Expression
+	}
+	return capture.Name
+}
+
+// captureContext lazily parses the body of a response, once for all the
+// captures which need it.
+type captureContext struct {
+	res     *http.Response
+	body    *responseBodyInfo
+	xmlDoc  *xmlNode
+	htmlDoc *html.Node
+}
+
+func runCaptures(captures []ResponseCapture, res *http.Response, body *responseBodyInfo) (Variables, error) {
+	vars := Variables{}
+	ctx := &captureContext{res: res, body: body}
+	for _, capture := range captures {
+		val, err := ctx.capture(capture)
+		if err != nil {
+			return nil, fmt.Errorf("unable to capture %v: %v", capture.Name, err)
+		}
+		vars[capture.Name] = val
+	}
+	return vars, nil
+}
+
+func (ctx *captureContext) capture(capture ResponseCapture) (string, error) {
+	expr, err := capture.expression()
+	if err != nil {
+		return "", err
+	}
+
+	switch capture.Source {
+	case ResponseCaptureHeader:
+		return ctx.res.Header.Get(capture.key()), nil
+
+	case ResponseCaptureBody:
+		if ctx.body.ParseError != nil {
+			return "", ctx.body.ParseError
+		}
+		val, err := expr.(*bodyQuery).evaluate(ctx.body.Parsed)
+		if err != nil {
+			return "", err
+		}
+		return formatValue(val), nil
+
+	case ResponseCaptureRegex:
+		match := expr.(*regexp.Regexp).FindSubmatch(ctx.body.Body)
+		if match == nil {
+			return "", fmt.Errorf("body doesn't match %v", capture.Expression)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+
+	case ResponseCaptureXpath:
+		if ctx.xmlDoc == nil {
+			doc, err := parseXml(ctx.body.Body)
+			if err != nil {
+				return "", err
+			}
+			ctx.xmlDoc = doc
+		}
+		return expr.(xpathQuery).query(ctx.xmlDoc)
+
+	case ResponseCaptureCss:
+		if ctx.htmlDoc == nil {
+			doc, err := html.Parse(bytes.NewReader(ctx.body.Body))
+			if err != nil {
+				return "", fmt.Errorf("unable to parse html body: %v", err)
+			}
+			ctx.htmlDoc = doc
+		}
+		return queryCss(ctx.htmlDoc, expr.(cascadia.Selector), capture.Expression, capture.Attribute)
+
+	case ResponseCaptureStatus:
+		return strconv.Itoa(ctx.res.StatusCode), nil
+
+	case ResponseCaptureCookie:
+		for _, cookie := range ctx.res.Cookies() {
+			if cookie.Name == capture.key() {
+				return cookie.Value, nil
+			}
+		}
+		return "", fmt.Errorf("cookie %v not set", capture.key())
+
+	case ResponseCaptureUrl:
+		return ctx.res.Request.URL.String(), nil
+	}
+
+	return "", fmt.Errorf("unknown capture source '%v'", capture.Source)
+}
+
+// queryCss returns the text, or the given attribute, of the first element
+// matching a css selector.
+func queryCss(doc *html.Node, sel cascadia.Selector, selector string, attribute string) (string, error) {
+	node := cascadia.Query(doc, sel)
+	if node == nil {
+		return "", fmt.Errorf("no match for '%v'", selector)
+	}
+
+	if attribute == "" {
+		return strings.TrimSpace(htmlText(node)), nil
+	}
+	for _, attr := range node.Attr {
+		if attr.Key == attribute {
+			return attr.Val, nil
+		}
+	}
+	return "", fmt.Errorf("'%v' has no attribute %v", selector, attribute)
+}
+
+func htmlText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var buf bytes.Buffer
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		buf.WriteString(htmlText(child))
+	}
+	return buf.String()
+}
+
+func compileCss(selector string) (cascadia.Selector, error) {
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid css selector '%v': %v", selector, err)
+	}
+	return sel, nil
+}
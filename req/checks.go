@@ -0,0 +1,277 @@
+package req
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseCheck is an assertion on a response. Each check should set one of
+// Status, Header, Json, BodyMatches or MaxLatency:
+//
+//   - Status: the allowed status codes, e.g. "2xx", "200-299" or "200,204"
+//   - Header: the name of a header, which must match Equals or Matches
+//   - Json: an expression into the json body, in the given Syntax, which must
+//     match Equals or Matches
+//   - BodyMatches: a regular expression the raw body must match
+//   - MaxLatency: the maximum latency of the response
+//
+// Not inverts the check, e.g. to fail on a body matching an error payload.
+type ResponseCheck struct {
+	Name        string
+	Status      string
+	Header      string
+	Json        string
+	Syntax      ExpressionSyntax
+	BodyMatches string
+	Equals      interface{}
+	Matches     string
+	MaxLatency  Duration
+	Not         bool
+	bodyRe      *regexp.Regexp // the expressions compiled with the template
+	matchesRe   *regexp.Regexp // unless templated
+	json        *bodyQuery
+}
+
+// CheckResult is the outcome of a check, with the reason of its failure.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (check ResponseCheck) needsBody() bool {
+	return check.Json != "" || check.BodyMatches != ""
+}
+
+// compile compiles the expressions of a check once, for all the responses.
+// A templated Matches is only known once rendered, and is compiled for each
+// response instead.
+func (check *ResponseCheck) compile(templatedMatches bool) error {
+	var err error
+	if check.BodyMatches != "" {
+		if check.bodyRe, err = compileRegexp(check.BodyMatches); err != nil {
+			return err
+		}
+	}
+	if check.Matches != "" && !templatedMatches {
+		if check.matchesRe, err = compileRegexp(check.Matches); err != nil {
+			return err
+		}
+	}
+	if check.Json != "" {
+		if check.json, err = compileBodyQuery(check.Json, check.Syntax); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (check ResponseCheck) describe() string {
+	if check.Name != "" {
+		return check.Name
+	}
+
+	var desc string
+	switch {
+	case check.Status != "":
+		desc = "status " + check.Status
+	case check.Header != "":
+		desc = "header " + check.Header + check.describeExpected()
+	case check.Json != "":
+		desc = "json " + check.Json + check.describeExpected()
+	case check.BodyMatches != "":
+		desc = "body ~ " + check.BodyMatches
+	case check.MaxLatency > 0:
+		desc = "latency <= " + check.MaxLatency.String()
+	default:
+		desc = "empty check"
+	}
+
+	if check.Not {
+		desc = "not " + desc
+	}
+	return desc
+}
+
+func (check ResponseCheck) describeExpected() string {
+	if check.Matches != "" {
+		return " ~ " + check.Matches
+	}
+	return fmt.Sprintf(" = %v", check.Equals)
+}
+
+// hasStatusCheck tells whether the checks assert the status, in which case an
+// error status doesn't fail the response by itself.
+func hasStatusCheck(checks []ResponseCheck) bool {
+	for _, check := range checks {
+		if check.Status != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// runChecks evaluates the checks of a request against its response.
+func runChecks(checks []ResponseCheck, res *http.Response, body responseBodyInfo, latency time.Duration) []CheckResult {
+	if len(checks) == 0 {
+		return nil
+	}
+
+	results := make([]CheckResult, len(checks))
+	for i, check := range checks {
+		passed, reason := runCheck(check, res, body, latency)
+		if check.Not {
+			passed = !passed
+			if !passed {
+				inverse := check
+				inverse.Name, inverse.Not = "", false
+				reason = inverse.describe() + " passed"
+			}
+		}
+		if passed {
+			reason = ""
+		}
+		results[i] = CheckResult{Name: check.describe(), Passed: passed, Reason: reason}
+	}
+	return results
+}
+
+func runCheck(check ResponseCheck, res *http.Response, body responseBodyInfo, latency time.Duration) (bool, string) {
+	switch {
+	case check.Status != "":
+		ok, err := statusMatches(check.Status, res.StatusCode)
+		if err != nil {
+			return false, err.Error()
+		}
+		if !ok {
+			return false, fmt.Sprintf("status was %v", res.StatusCode)
+		}
+		return true, ""
+
+	case check.Header != "":
+		values, ok := res.Header[http.CanonicalHeaderKey(check.Header)]
+		if !ok {
+			return false, fmt.Sprintf("header %v is missing", check.Header)
+		}
+		return checkValue(check, strings.Join(values, ", "))
+
+	case check.Json != "":
+		if body.ParseError != nil {
+			return false, fmt.Sprintf("body is not json: %v", body.ParseError)
+		}
+		query := check.json
+		if query == nil {
+			var err error
+			if query, err = compileBodyQuery(check.Json, check.Syntax); err != nil {
+				return false, err.Error()
+			}
+		}
+		val, err := query.evaluate(body.Parsed)
+		if err != nil {
+			return false, err.Error()
+		}
+		return checkValue(check, val)
+
+	case check.BodyMatches != "":
+		re, err := compiledRegexp(check.bodyRe, check.BodyMatches)
+		if err != nil {
+			return false, err.Error()
+		}
+		if !re.Match(body.Body) {
+			return false, fmt.Sprintf("body doesn't match %v", check.BodyMatches)
+		}
+		return true, ""
+
+	case check.MaxLatency > 0:
+		if latency > time.Duration(check.MaxLatency) {
+			return false, fmt.Sprintf("latency was %v", latency)
+		}
+		return true, ""
+	}
+
+	return false, "check has nothing to check"
+}
+
+// checkValue compares a value with the check's expectations. Values are
+// compared as strings, so that e.g. 1 and "1" are equal.
+func checkValue(check ResponseCheck, val interface{}) (bool, string) {
+	actual := formatValue(val)
+
+	if check.Matches != "" {
+		re, err := compiledRegexp(check.matchesRe, check.Matches)
+		if err != nil {
+			return false, err.Error()
+		}
+		if !re.MatchString(actual) {
+			return false, fmt.Sprintf("'%v' doesn't match %v", actual, check.Matches)
+		}
+		return true, ""
+	}
+
+	expected := formatValue(check.Equals)
+	if check.Equals != nil && actual != expected {
+		return false, fmt.Sprintf("expected '%v', got '%v'", expected, actual)
+	}
+	return true, ""
+}
+
+// statusMatches checks a status code against a comma-separated list of
+// codes ("200"), classes ("2xx") and ranges ("200-299").
+func statusMatches(spec string, status int) (bool, error) {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, err := parseStatusRange(part)
+		if err != nil {
+			return false, err
+		}
+		if status >= lo && status <= hi {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parseStatusRange(part string) (int, int, error) {
+	if len(part) == 3 && strings.HasSuffix(strings.ToLower(part), "xx") {
+		class, err := strconv.Atoi(part[:1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid status class '%v'", part)
+		}
+		return class * 100, class*100 + 99, nil
+	}
+
+	bounds := strings.SplitN(part, "-", 2)
+	lo, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status '%v'", part)
+	}
+	hi := lo
+	if len(bounds) == 2 {
+		hi, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid status range '%v'", part)
+		}
+	}
+	return lo, hi, nil
+}
+
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression '%v': %v", expr, err)
+	}
+	return re, nil
+}
+
+// compiledRegexp returns the regular expression compiled with the template,
+// or compiles expr when it was templated.
+func compiledRegexp(re *regexp.Regexp, expr string) (*regexp.Regexp, error) {
+	if re != nil {
+		return re, nil
+	}
+	return compileRegexp(expr)
+}
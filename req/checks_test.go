@@ -0,0 +1,48 @@
+package req
+
+import (
+	"testing"
+)
+
+func TestStatusMatches(t *testing.T) {
+	tests := []struct {
+		spec    string
+		status  int
+		matches bool
+	}{
+		{"200", 200, true},
+		{"200", 201, false},
+		{"2xx", 200, true},
+		{"2xx", 299, true},
+		{"2XX", 204, true},
+		{"2xx", 300, false},
+		{"2xx", 199, false},
+		{"200-299", 250, true},
+		{"200 - 299", 299, true},
+		{"200-299", 300, false},
+		{"200,204", 204, true},
+		{"200, 204", 201, false},
+		{"2xx,404", 404, true},
+		{"301-302, 5xx", 503, true},
+		{"301-302, 5xx", 303, false},
+	}
+
+	for _, test := range tests {
+		matches, err := statusMatches(test.spec, test.status)
+		if err != nil {
+			t.Errorf("%v: %v", test.spec, err)
+			continue
+		}
+		if matches != test.matches {
+			t.Errorf("%v with %v: expected %v, got %v", test.spec, test.status, test.matches, matches)
+		}
+	}
+}
+
+func TestStatusMatchesInvalid(t *testing.T) {
+	for _, spec := range []string{"", "ok", "axx", "200-", "-299", "200-2xx"} {
+		if _, err := statusMatches(spec, 200); err == nil {
+			t.Errorf("%v: expected an error", spec)
+		}
+	}
+}
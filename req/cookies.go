@@ -0,0 +1,101 @@
+package req
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+)
+
+// cookieJar keeps the cookies of a virtual user, to send them back with its
+// next requests, and the last value of each cookie by name, for templates to
+// read as .cookies.
+type cookieJar struct {
+	sync.Mutex
+	jar    *cookiejar.Jar
+	values map[string]string
+}
+
+// newCookieJar returns a jar if cookies are enabled, or nil.
+func newCookieJar(enabled bool) *cookieJar {
+	if !enabled {
+		return nil
+	}
+	// cookiejar.New only fails with invalid options
+	jar, _ := cookiejar.New(nil)
+	return &cookieJar{jar: jar, values: map[string]string{}}
+}
+
+// send adds the cookies of the jar which match the request.
+func (jar *cookieJar) send(req *http.Request) {
+	if jar == nil {
+		return
+	}
+	for _, cookie := range jar.jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+}
+
+// record saves the cookies set by a response.
+func (jar *cookieJar) record(u *url.URL, res *http.Response) {
+	cookies := res.Cookies()
+	if jar == nil || len(cookies) == 0 {
+		return
+	}
+	jar.jar.SetCookies(u, cookies)
+
+	jar.Lock()
+	defer jar.Unlock()
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 {
+			delete(jar.values, cookie.Name)
+		} else {
+			jar.values[cookie.Name] = cookie.Value
+		}
+	}
+}
+
+// variables copies the values of the cookies, for templates to render
+// while other requests update the jar.
+func (jar *cookieJar) variables() map[string]string {
+	jar.Lock()
+	defer jar.Unlock()
+	values := make(map[string]string, len(jar.values))
+	for name, value := range jar.values {
+		values[name] = value
+	}
+	return values
+}
+
+// userJars gives each virtual user outside of sessions its own cookie jar:
+// each worker of the templates, whose jar it keeps from one step to the
+// next. At a constant rate, each request is a new user, with a new jar.
+type userJars struct {
+	sync.Mutex
+	enabled bool
+	jars    map[uint]*cookieJar
+}
+
+func newUserJars(enabled bool) *userJars {
+	return &userJars{enabled: enabled, jars: map[uint]*cookieJar{}}
+}
+
+// worker returns the jar of a worker, or nil if cookies are disabled.
+func (jars *userJars) worker(id uint) *cookieJar {
+	if !jars.enabled {
+		return nil
+	}
+	jars.Lock()
+	defer jars.Unlock()
+	jar, ok := jars.jars[id]
+	if !ok {
+		jar = newCookieJar(true)
+		jars.jars[id] = jar
+	}
+	return jar
+}
+
+// arrival returns the jar of a request sent at a constant rate.
+func (jars *userJars) arrival() *cookieJar {
+	return newCookieJar(jars.enabled)
+}
@@ -0,0 +1,52 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCookieJarPerWorker(t *testing.T) {
+	var lock sync.Mutex
+	logins := 0
+	seen := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// slow enough that each worker takes one of the requests
+		time.Sleep(50 * time.Millisecond)
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path == "/login" {
+			logins++
+			http.SetCookie(w, &http.Cookie{Name: "user", Value: fmt.Sprint(logins)})
+			return
+		}
+		cookie, err := r.Cookie("user")
+		if err != nil {
+			t.Errorf("expected a user cookie: %v", err)
+			return
+		}
+		seen[cookie.Value]++
+	}))
+	defer server.Close()
+
+	scen := RequestScenario{
+		Requests: []RequestTemplate{
+			{Url: server.URL + "/login", Concurrency: 4},
+			{Url: server.URL + "/home", Concurrency: 4},
+		},
+		Options: Options{Cookies: true},
+	}
+	if err := Execute(context.Background(), scen, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// each worker keeps the cookie of its own login
+	if len(seen) != 4 {
+		t.Errorf("expected 4 users, got %v", seen)
+	}
+}
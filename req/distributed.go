@@ -4,14 +4,20 @@ package req
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"sync"
+	"time"
 )
 
-func execScenarioDistributed(scenario RequestScenario, chans execChans) {
+// botCancelTimeout bounds the time to tell a bot to stop its run.
+const botCancelTimeout = 5 * time.Second
+
+func execScenarioDistributed(ctx context.Context, scenario RequestScenario, chans execChans) {
 
 	loadSelfSignedCertificate()
 	// transport.TLSClientConfig = tlsConfig()
@@ -19,45 +25,235 @@ func execScenarioDistributed(scenario RequestScenario, chans execChans) {
 	go func() {
 		var wg sync.WaitGroup
 
+		// stopping the run stops the bots, which then send the rest of their
+		// output
+		stopBots := context.AfterFunc(ctx, func() { cancelBots(scenario.Bots) })
+
 		for idx, _ := range scenario.Bots {
 			wg.Add(1)
 			go func(idx uint) {
 				defer wg.Done()
-				execScenarioFromBot(idx, scenario, chans)
+				execScenarioFromBot(ctx, idx, scenario, chans)
 			}(uint(idx))
 		}
 
 		wg.Wait()
+		stopBots()
 		chans.Done <- true
 
 	}()
 }
 
-func execScenarioFromBot(botIdx uint, scenario RequestScenario, chans execChans) {
-	botScenario := makeBotScenario(botIdx, scenario)
+func execScenarioFromBot(ctx context.Context, botIdx uint, scenario RequestScenario, chans execChans) {
+	botScenario, ok, err := makeBotScenario(botIdx, scenario)
+	if err != nil {
+		chans.Errs <- err
+		return
+	}
+	if !ok {
+		// less load than bots: nothing left for this one
+		return
+	}
+
 	data, err := encodeScenario(botScenario)
 
 	if err == nil {
 		bot := scenario.Bots[botIdx]
-		err = sendToBot(bot, data, chans.Out)
+		err = sendToBot(ctx, bot, data, chans.Out)
 	}
 
-	if err != nil {
+	// a cancelled run cuts the bots' output short, which isn't an error
+	if err != nil && ctx.Err() == nil {
 		chans.Errs <- err
 	}
 }
 
-func makeBotScenario(botIdx uint, scenario RequestScenario) RequestScenario {
+// makeBotScenario makes the share of a bot of the scenario, and tells
+// whether it has anything to run. The load settings (concurrency, rate,
+// stages, users and iterations) are for the whole scenario, and are split
+// among the bots, while a count of requests is per worker, so that the total
+// load doesn't depend on the number of bots.
+func makeBotScenario(botIdx uint, scenario RequestScenario) (RequestScenario, bool, error) {
+	botCount := uint(len(scenario.Bots))
 	scenario.Bots = nil //dont send bot list to bots or we will have infinite recursion
 
-	botReqs := make([]RequestTemplate, len(scenario.Requests))
-	for i, req := range scenario.Requests {
-		req.StartIdx = botIdx * req.Count
+	// thresholds are checked against the results of all the bots, not by each
+	scenario.Thresholds = nil
+
+	// the load settings of the templates only apply to steps
+	steps := scenario.Sessions == nil && scenario.Mix == nil
+	scenario.Requests = makeBotRequests(botIdx, botCount, "", scenario.Requests, steps)
 
-		botReqs[i] = req
+	botFeeders := make([]Feeder, len(scenario.Feeders))
+	for i, feeder := range scenario.Feeders {
+		botFeeders[i] = feeder.partition(botIdx, botCount)
+	}
+	scenario.Feeders = botFeeders
+
+	switch {
+	case scenario.Sessions != nil:
+		sessions, err := makeBotSessions(botIdx, botCount, *scenario.Sessions)
+		if err != nil {
+			return scenario, false, err
+		}
+		scenario.Sessions = &sessions
+		return scenario, sessions.Iterations > 0 || sessions.Duration > 0, nil
+	case scenario.Mix != nil:
+		mix, ok := makeBotMix(botIdx, botCount, *scenario.Mix)
+		scenario.Mix = &mix
+		return scenario, ok, nil
+	default:
+		return scenario, len(scenario.Requests) > 0, nil
 	}
-	scenario.Requests = botReqs
-	return scenario
+}
+
+// makeBotRequests splits the load of the templates among the bots. With
+// skipEmpty, the templates of which the bot gets no share, when they have
+// fewer workers than there are bots, are left out; all templates are named
+// after their step beforehand, so that leaving one out doesn't rename the
+// following ones.
+func makeBotRequests(botIdx uint, botCount uint, parent string, reqs []RequestTemplate, skipEmpty bool) []RequestTemplate {
+	var botReqs []RequestTemplate
+	for i, req := range reqs {
+		req.Name = stepName(parent, i, req)
+		// interleave the bots' indexes, so they stay unique even when the
+		// number of requests isn't known in advance (duration-based runs)
+		stride := req.IdxStride
+		if stride == 0 {
+			stride = requestTemplateDefaults.IdxStride
+		}
+		req.StartIdx += botIdx * stride
+		req.IdxStride = stride * botCount
+		req.Thresholds = nil
+
+		concurrency := req.Concurrency
+		if concurrency == 0 {
+			concurrency = requestTemplateDefaults.Concurrency
+		}
+		req.Concurrency = splitConcurrency(concurrency, botIdx, botCount)
+		req.Rate = req.Rate.Scale(1 / float64(botCount))
+		req.MaxInFlight = splitInFlight(req.MaxInFlight, requestTemplateDefaults.MaxInFlight, botIdx, botCount)
+		if len(req.Stages) > 0 {
+			req.Stages = makeBotStages(botIdx, botCount, req.Stages)
+		}
+
+		if len(req.Group) > 0 {
+			req.Group = makeBotRequests(botIdx, botCount, req.Name, req.Group, skipEmpty)
+			if len(req.Group) == 0 {
+				continue
+			}
+		} else if skipEmpty && emptyShare(req.Concurrency, req.Count, req.Duration, req.Rate, req.Stages) {
+			continue
+		}
+
+		botReqs = append(botReqs, req)
+	}
+	return botReqs
+}
+
+// makeBotMix splits the load of a mix among the bots, as for a template, and
+// tells whether the bot got a share of it.
+func makeBotMix(botIdx uint, botCount uint, mix Mix) (Mix, bool) {
+	stride := mix.IdxStride
+	if stride == 0 {
+		stride = mixDefaults.IdxStride
+	}
+	mix.StartIdx += botIdx * stride
+	mix.IdxStride = stride * botCount
+
+	concurrency := mix.Concurrency
+	if concurrency == 0 {
+		concurrency = mixDefaults.Concurrency
+	}
+	mix.Concurrency = splitConcurrency(concurrency, botIdx, botCount)
+	mix.Rate = mix.Rate.Scale(1 / float64(botCount))
+	mix.MaxInFlight = splitInFlight(mix.MaxInFlight, mixDefaults.MaxInFlight, botIdx, botCount)
+	if len(mix.Stages) > 0 {
+		mix.Stages = makeBotStages(botIdx, botCount, mix.Stages)
+	}
+	return mix, !emptyShare(mix.Concurrency, mix.Count, mix.Duration, mix.Rate, mix.Stages)
+}
+
+// emptyShare tells whether a bot's share of a load is empty, which happens
+// when there are fewer workers than bots. A rate is never split down to zero,
+// but a count of requests is per worker, with a rate too: a bot without
+// workers has no requests to make, and must sit the load out rather than
+// fall back to the default concurrency.
+func emptyShare(concurrency uint, count uint, duration Duration, rate Rate, stages []Stage) bool {
+	counted := count > 0 || (duration == 0 && len(stages) == 0)
+	if concurrency == 0 && counted {
+		return true
+	}
+	if !rate.IsZero() {
+		return false
+	}
+	for _, stage := range stages {
+		if stage.Concurrency > 0 || !stage.Rate.IsZero() {
+			return false
+		}
+	}
+	return concurrency == 0
+}
+
+// makeBotSessions splits the users and sessions among the bots. The defaults
+// are applied first, so that a bot whose share is empty doesn't fall back to
+// them: it gets no sessions at all instead.
+func makeBotSessions(botIdx uint, botCount uint, sessions Sessions) (Sessions, error) {
+	if err := mergeSessionsWithDefaults(&sessions); err != nil {
+		return sessions, err
+	}
+
+	sessions.StartIdx += botIdx * sessions.IdxStride
+	sessions.IdxStride *= botCount
+	if sessions.Iterations > 0 {
+		sessions.Iterations = splitConcurrency(sessions.Iterations, botIdx, botCount)
+		if sessions.Iterations == 0 {
+			return Sessions{}, nil
+		}
+	}
+	sessions.Rate = sessions.Rate.Scale(1 / float64(botCount))
+	sessions.Users = splitConcurrency(sessions.Users, botIdx, botCount)
+	if len(sessions.Stages) > 0 {
+		sessions.Stages = makeBotStages(botIdx, botCount, sessions.Stages)
+	} else if sessions.Users == 0 {
+		// the bot still runs its share of sessions, when there are more
+		// sessions than users
+		sessions.Users = 1
+	}
+	return sessions, nil
+}
+
+func makeBotStages(botIdx uint, botCount uint, stages []Stage) []Stage {
+	botStages := make([]Stage, len(stages))
+	for i, stage := range stages {
+		stage.Concurrency = splitConcurrency(stage.Concurrency, botIdx, botCount)
+		stage.Rate = stage.Rate.Scale(1 / float64(botCount))
+		botStages[i] = stage
+	}
+	return botStages
+}
+
+// splitConcurrency returns the bot's share of the concurrency, the remainder
+// going to the first bots.
+func splitConcurrency(concurrency uint, botIdx uint, botCount uint) uint {
+	share := concurrency / botCount
+	if botIdx < concurrency%botCount {
+		share++
+	}
+	return share
+}
+
+// splitInFlight returns the bot's share of a limit of requests in flight.
+// Each bot gets a share of the rate, and so at least one request in flight.
+func splitInFlight(maxInFlight uint, dflt uint, botIdx uint, botCount uint) uint {
+	if maxInFlight == 0 {
+		maxInFlight = dflt
+	}
+	share := splitConcurrency(maxInFlight, botIdx, botCount)
+	if share == 0 {
+		share = 1
+	}
+	return share
 }
 
 func encodeScenario(scenario RequestScenario) ([]byte, error) {
@@ -68,8 +264,10 @@ func encodeScenario(scenario RequestScenario) ([]byte, error) {
 	return data, nil
 }
 
-func sendToBot(bot BotInfo, data []byte, out chan ResponseInfo) error {
-	resp, err := execBotRequest(bot, data)
+// sendToBot runs a scenario on a bot, which stops when ctx is cancelled, as
+// the bot request is.
+func sendToBot(ctx context.Context, bot BotInfo, data []byte, out chan ResponseInfo) error {
+	resp, err := execBotRequest(ctx, bot, data)
 	if err != nil {
 		return botError("failed to exec bot request", err, bot, data)
 	}
@@ -91,8 +289,8 @@ func sendToBot(bot BotInfo, data []byte, out chan ResponseInfo) error {
 	return nil
 }
 
-func execBotRequest(bot BotInfo, data []byte) (*http.Response, error) {
-	req, err := http.NewRequest("POST", bot.Url, bytes.NewBuffer(data))
+func execBotRequest(ctx context.Context, bot BotInfo, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(requestContext(ctx), "POST", bot.Url, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -108,6 +306,45 @@ func execBotRequest(bot BotInfo, data []byte) (*http.Response, error) {
 	return resp, nil
 }
 
+// cancelBots tells the bots to stop their runs, so that they stop sending
+// requests and release their execution lock.
+func cancelBots(bots []BotInfo) {
+	var wg sync.WaitGroup
+	for _, bot := range bots {
+		wg.Add(1)
+		go func(bot BotInfo) {
+			defer wg.Done()
+			if err := cancelBot(bot); err != nil {
+				log.Printf("*** ERROR *** Unable to stop bot %v: %v\n", bot.Url, err)
+			}
+		}(bot)
+	}
+	wg.Wait()
+}
+
+func cancelBot(bot BotInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), botCancelTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", bot.Url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("API-KEY", bot.ApiKey)
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// a bot which already completed its run has nothing to stop
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response status %v, body: %v", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
 func readBotResponse(reader *bufio.Reader, out chan ResponseInfo) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
@@ -0,0 +1,42 @@
+package req
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMakeBotRequestsSkipsEmptyShares(t *testing.T) {
+	rate, err := ParseRate("10/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := []RequestTemplate{
+		{Url: "http://localhost/counted", Count: 100, Concurrency: 3},
+		{Url: "http://localhost/rated", Count: 100, Concurrency: 3, Rate: rate},
+		{Url: "http://localhost/timed", Duration: Duration(time.Minute), Concurrency: 3, Rate: rate},
+	}
+
+	tests := []struct {
+		botIdx   uint
+		expected []string
+	}{
+		{0, []string{"http://localhost/counted", "http://localhost/rated", "http://localhost/timed"}},
+		// the last bot gets no worker, and so none of the counted requests,
+		// but still its share of the rate for a duration
+		{3, []string{"http://localhost/timed"}},
+	}
+
+	for _, test := range tests {
+		botReqs := makeBotRequests(test.botIdx, 4, "", reqs, true)
+		if len(botReqs) != len(test.expected) {
+			t.Errorf("bot %v: expected %v templates, got %v", test.botIdx, len(test.expected), len(botReqs))
+			continue
+		}
+		for i, req := range botReqs {
+			if req.Url != test.expected[i] {
+				t.Errorf("bot %v: expected %v, got %v", test.botIdx, test.expected[i], req.Url)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package req
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is encoded in json as a human-readable
+// string, such as "10m" or "1h30m". Plain numbers are read as seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch val := raw.(type) {
+	case float64:
+		*d = Duration(val * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid duration '%v': %v", val, err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %v", string(data))
+	}
+
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
@@ -0,0 +1,49 @@
+package req
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationJson(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected time.Duration
+	}{
+		{`"10m"`, 10 * time.Minute},
+		{`"1h30m"`, 90 * time.Minute},
+		{`"250ms"`, 250 * time.Millisecond},
+		{`90`, 90 * time.Second},
+		{`1.5`, 1500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		var duration Duration
+		if err := json.Unmarshal([]byte(test.json), &duration); err != nil {
+			t.Errorf("%v: %v", test.json, err)
+			continue
+		}
+		if time.Duration(duration) != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.json, test.expected, duration)
+		}
+
+		data, err := json.Marshal(duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Duration
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != duration {
+			t.Errorf("%v: expected %v to decode to %v, got %v (%v)", test.json, string(data), duration, decoded, err)
+		}
+	}
+}
+
+func TestDurationJsonInvalid(t *testing.T) {
+	for _, data := range []string{`"10"`, `"ten minutes"`, `""`, `true`, `{}`} {
+		var duration Duration
+		if err := json.Unmarshal([]byte(data), &duration); err == nil {
+			t.Errorf("%v: expected an error, got %v", data, duration)
+		}
+	}
+}
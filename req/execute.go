@@ -1,10 +1,13 @@
 package req
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"time"
 )
 
 type execChans struct {
@@ -14,21 +17,56 @@ type execChans struct {
 }
 
 type scenarioExecutor interface {
-	execute(scen RequestScenario, chans execChans)
+	execute(ctx context.Context, scen RequestScenario, chans execChans)
 }
 
-func Execute(scen RequestScenario, writer io.Writer) error {
+const defaultGracePeriod = 10 * time.Second
+
+// botGraceMargin is how much longer than the bots htflood waits for their
+// output, as they apply the grace period themselves.
+const botGraceMargin = 5 * time.Second
+
+var errDeadlineReached = errors.New("deadline reached")
+
+// Execute runs a scenario, and writes its responses to writer. Cancelling ctx
+// stops the run gracefully: no new requests are sent, and those in flight
+// have the options' grace period to complete before they are dropped. When
+// the scenario's deadline passes, they are dropped right away.
+func Execute(ctx context.Context, scen RequestScenario, writer io.Writer) error {
+
+	if err := scen.validate(); err != nil {
+		return err
+	}
 
 	setOptions(scen.Options)
 
 	explainScenario(scen)
 
+	if err := loadFeeders(scen.Feeders); err != nil {
+		return err
+	}
+
+	ctx, stop, interrupt := withGracefulStop(ctx, scen)
+	defer interrupt()
+
 	chans := execChans{
 		Out:  make(chan ResponseInfo),
 		Errs: make(chan error),
 		Done: make(chan bool),
 	}
-	execScenario(scen, chans)
+	execScenario(ctx, scen, chans)
+
+	// a run which ends early, e.g. on an aborting threshold, is cancelled,
+	// and its executors are unblocked until they are done
+	done := false
+	defer func() {
+		stop(nil)
+		if !done {
+			go drain(chans)
+		}
+	}()
+
+	thresholds := newThresholdSet(scen)
 
 	for {
 		select {
@@ -36,19 +74,97 @@ func Execute(scen RequestScenario, writer io.Writer) error {
 			if err := printResponse(res, writer); err != nil {
 				return err
 			}
+			thresholds.record(res)
+			if err := thresholds.checkAbort(); err != nil {
+				return err
+			}
 		case err := <-chans.Errs:
 			return err
 		case <-chans.Done:
-			return nil
+			done = true
+			if context.Cause(ctx) == errDeadlineReached {
+				log.Printf("Deadline of %v reached\n", scen.Deadline)
+			}
+			return checkThresholds(thresholds)
+		}
+	}
+}
+
+type requestContextKey struct{}
+
+// withGracefulStop returns the context of a run, which stops it when ctx is
+// cancelled or the deadline passes, and the functions to stop it and to
+// interrupt its requests. The requests have their own context, which
+// outlives the run's for a grace period, so that those in flight can
+// complete.
+func withGracefulStop(ctx context.Context, scen RequestScenario) (context.Context, context.CancelCauseFunc, context.CancelFunc) {
+	requests, interrupt := context.WithCancel(context.Background())
+	ctx, stop := context.WithCancelCause(ctx)
+
+	grace := time.Duration(scen.Options.GracePeriod)
+	if grace == 0 {
+		grace = defaultGracePeriod
+	}
+	if len(scen.Bots) > 0 {
+		grace += botGraceMargin
+	}
+
+	// bots apply the deadline themselves, and end their output when it passes
+	if scen.Deadline > 0 && len(scen.Bots) == 0 {
+		deadline := time.AfterFunc(time.Duration(scen.Deadline), func() {
+			interrupt()
+			stop(errDeadlineReached)
+		})
+		context.AfterFunc(requests, func() { deadline.Stop() })
+	}
+	context.AfterFunc(ctx, func() {
+		grace := time.AfterFunc(grace, interrupt)
+		context.AfterFunc(requests, func() { grace.Stop() })
+	})
+
+	return context.WithValue(ctx, requestContextKey{}, requests), stop, interrupt
+}
+
+// requestContext is the context for the requests of a run, which may outlive
+// the run's.
+func requestContext(ctx context.Context) context.Context {
+	if requests, ok := ctx.Value(requestContextKey{}).(context.Context); ok {
+		return requests
+	}
+	return ctx
+}
+
+func drain(chans execChans) {
+	for {
+		select {
+		case <-chans.Out:
+		case <-chans.Errs:
+		case <-chans.Done:
+			return
+		}
+	}
+}
+
+func checkThresholds(thresholds *thresholdSet) error {
+	var failed []ThresholdResult
+	for _, result := range thresholds.evaluate() {
+		log.Printf("Threshold %v\n", result)
+		if !result.Passed {
+			failed = append(failed, result)
 		}
 	}
+
+	if len(failed) > 0 {
+		return ThresholdsError{Failed: failed}
+	}
+	return nil
 }
 
-func execScenario(scen RequestScenario, chans execChans) {
+func execScenario(ctx context.Context, scen RequestScenario, chans execChans) {
 	if len(scen.Bots) == 0 {
-		execScenarioLocally(scen, chans)
+		execScenarioLocally(ctx, scen, chans)
 	} else {
-		execScenarioDistributed(scen, chans)
+		execScenarioDistributed(ctx, scen, chans)
 	}
 }
 
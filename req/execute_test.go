@@ -0,0 +1,115 @@
+package req
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecuteSetupErrorEndsExecutor(t *testing.T) {
+	scenarios := map[string]RequestScenario{
+		"steps":    {Requests: []RequestTemplate{{Url: "http://localhost", If: "{{ .x"}}},
+		"sessions": {Requests: []RequestTemplate{{Url: "http://localhost", If: "{{ .x"}}, Sessions: &Sessions{}},
+		"mix":      {Requests: []RequestTemplate{{Url: "http://localhost/{{ .x"}}, Mix: &Mix{}},
+	}
+
+	for name, scen := range scenarios {
+		before := runtime.NumGoroutine()
+		if err := Execute(context.Background(), scen, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", name)
+			continue
+		}
+
+		// the executor and the goroutine draining its channels both end
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if after := runtime.NumGoroutine(); after > before {
+			t.Errorf("%v: %v goroutines left running", name, after-before)
+		}
+	}
+}
+
+func TestExecuteStagesDownToNoWorkers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// the last stage leaves no worker to take the requests
+	stages := []Stage{{Duration: Duration(200 * time.Millisecond), Concurrency: 2}, {Duration: Duration(200 * time.Millisecond)}}
+	scen := RequestScenario{Requests: []RequestTemplate{{Url: server.URL, Stages: stages}}}
+
+	done := make(chan error)
+	go func() { done <- Execute(context.Background(), scen, ioutil.Discard) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run didn't end with its stages")
+	}
+}
+
+func TestExecuteAtRateLimitsRequestsInFlight(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// 100 requests/s of 100ms each would keep about 10 in flight
+	rate, err := ParseRate("100/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := RequestTemplate{Url: server.URL, Count: 20, Rate: rate, MaxInFlight: 2}
+	scen := RequestScenario{Requests: []RequestTemplate{tmpl}}
+
+	var out bytes.Buffer
+	if err := Execute(context.Background(), scen, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxInFlight != 2 {
+		t.Errorf("expected at most 2 requests in flight, got %v", maxInFlight)
+	}
+
+	// the requests waiting for a slot are late, by up to 900ms for the last
+	maxLag := 0.0
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var res ResponseInfo
+		if err := decoder.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.ScheduleLag > maxLag {
+			maxLag = res.ScheduleLag
+		}
+	}
+	if maxLag < 500 {
+		t.Errorf("expected the wait for a slot to count as lag, got at most %vms", maxLag)
+	}
+}
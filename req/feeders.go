@@ -0,0 +1,227 @@
+package req
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FeederFormat string
+
+const (
+	FeederCsv   FeederFormat = "csv"
+	FeederJsonl FeederFormat = "jsonl"
+)
+
+type FeederStrategy string
+
+const (
+	// FeederSequential uses each row once, in order, and ends the run when
+	// they are exhausted
+	FeederSequential FeederStrategy = "sequential"
+	// FeederCircular uses the rows in order, starting over when exhausted
+	FeederCircular FeederStrategy = "circular"
+	// FeederRandom picks a random row each time
+	FeederRandom FeederStrategy = "random"
+	// FeederUnique gives each virtual user its own row, for all its
+	// sessions. Without sessions, it uses each row once like sequential
+	FeederUnique FeederStrategy = "unique"
+)
+
+// Feeder feeds rows of data, from a csv (with a header line) or jsonl File,
+// to the requests' templates. The current row is exposed as the variable
+// Name, e.g. {{ .account.email }}, or its columns as variables if Name is
+// empty. With sessions, all the requests of a session see the same row.
+//
+// The rows are loaded when the scenario starts, and split among the bots
+// when it's distributed, so that each gets a disjoint partition.
+type Feeder struct {
+	Name     string
+	File     string
+	Format   FeederFormat
+	Strategy FeederStrategy
+	Rows     []Variables
+}
+
+// loadFeeders reads the feeders' files into their rows.
+func loadFeeders(feeders []Feeder) error {
+	for i := range feeders {
+		feeder := &feeders[i]
+		if feeder.File == "" {
+			continue
+		}
+
+		rows, err := readFeederFile(feeder.File, feeder.Format)
+		if err != nil {
+			return err
+		}
+		feeder.Rows = append(feeder.Rows, rows...)
+		feeder.File = ""
+	}
+	return nil
+}
+
+func readFeederFile(path string, format FeederFormat) ([]Variables, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open feeder file: %v", err)
+	}
+	defer file.Close()
+
+	if format == "" {
+		format = FeederFormat(strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+	}
+
+	var rows []Variables
+	switch format {
+	case FeederCsv:
+		rows, err = readCsvRows(file)
+	case FeederJsonl, "json", "ndjson":
+		rows, err = readJsonlRows(file)
+	default:
+		return nil, fmt.Errorf("unknown format '%v' of feeder file %v", format, path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read feeder file %v: %v", path, err)
+	}
+	return rows, nil
+}
+
+func readCsvRows(reader io.Reader) ([]Variables, error) {
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	rows := make([]Variables, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := Variables{}
+		for i, name := range header {
+			if i < len(record) {
+				row[name] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readJsonlRows(reader io.Reader) ([]Variables, error) {
+	var rows []Variables
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var row Variables
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// partition returns the bot's share of the rows, interleaved. Random and
+// circular feeders reuse their rows anyway, so a bot left without any share
+// gets all of them.
+func (feeder Feeder) partition(botIdx uint, botCount uint) Feeder {
+	var rows []Variables
+	for i := botIdx; i < uint(len(feeder.Rows)); i += botCount {
+		rows = append(rows, feeder.Rows[i])
+	}
+
+	if len(rows) == 0 && (feeder.Strategy == FeederRandom || feeder.Strategy == FeederCircular) {
+		return feeder
+	}
+	feeder.Rows = rows
+	return feeder
+}
+
+// feederSet draws the rows of the feeders during a run. It is shared by the
+// workers of a template, or by the users of sessions.
+type feederSet struct {
+	sync.Mutex
+	feeders []Feeder
+	next    []int
+	random  *rand.Rand
+}
+
+func newFeederSet(feeders []Feeder) (*feederSet, error) {
+	for _, feeder := range feeders {
+		switch feeder.Strategy {
+		case "", FeederSequential, FeederCircular, FeederRandom, FeederUnique:
+		default:
+			return nil, fmt.Errorf("unknown strategy '%v' of feeder %v", feeder.Strategy, feeder.Name)
+		}
+		if len(feeder.Rows) == 0 && feeder.Strategy != FeederSequential && feeder.Strategy != "" {
+			return nil, fmt.Errorf("feeder %v has no rows", feeder.Name)
+		}
+	}
+
+	return &feederSet{
+		feeders: feeders,
+		next:    make([]int, len(feeders)),
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// checkUsers verifies that unique feeders have a row for each user.
+func (set *feederSet) checkUsers(users uint) error {
+	for _, feeder := range set.feeders {
+		if feeder.Strategy == FeederUnique && uint(len(feeder.Rows)) < users {
+			return fmt.Errorf("feeder %v has %v rows for %v users", feeder.Name, len(feeder.Rows), users)
+		}
+	}
+	return nil
+}
+
+// draw adds the next row of each feeder to vars, for the given virtual user,
+// or for no user in particular if user is negative. ok is false once a
+// feeder is exhausted.
+func (set *feederSet) draw(user int, vars Variables) (ok bool) {
+	set.Lock()
+	defer set.Unlock()
+
+	for i, feeder := range set.feeders {
+		var row Variables
+		switch {
+		case feeder.Strategy == FeederUnique && user >= 0:
+			row = feeder.Rows[user]
+		case feeder.Strategy == FeederCircular:
+			row = feeder.Rows[set.next[i]%len(feeder.Rows)]
+			set.next[i]++
+		case feeder.Strategy == FeederRandom:
+			row = feeder.Rows[set.random.Intn(len(feeder.Rows))]
+		default:
+			if set.next[i] >= len(feeder.Rows) {
+				return false
+			}
+			row = feeder.Rows[set.next[i]]
+			set.next[i]++
+		}
+
+		if feeder.Name != "" {
+			vars[feeder.Name] = row
+		} else {
+			for key, val := range row {
+				vars[key] = val
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package req
+
+import (
+	"reflect"
+	"testing"
+)
+
+func feederTestRows(count int) []Variables {
+	rows := make([]Variables, count)
+	for i := range rows {
+		rows[i] = Variables{"id": i}
+	}
+	return rows
+}
+
+func rowIds(rows []Variables) []int {
+	ids := []int{}
+	for _, row := range rows {
+		ids = append(ids, row["id"].(int))
+	}
+	return ids
+}
+
+func TestFeederPartition(t *testing.T) {
+	feeder := Feeder{Name: "users", Rows: feederTestRows(7)}
+
+	expected := [][]int{{0, 3, 6}, {1, 4}, {2, 5}}
+	for botIdx, ids := range expected {
+		partition := feeder.partition(uint(botIdx), 3)
+		if actual := rowIds(partition.Rows); !reflect.DeepEqual(actual, ids) {
+			t.Errorf("bot %v: expected rows %v, got %v", botIdx, ids, actual)
+		}
+		if partition.Name != feeder.Name {
+			t.Errorf("bot %v: expected the feeder's settings to be kept", botIdx)
+		}
+	}
+
+	if ids := rowIds(feeder.partition(0, 1).Rows); !reflect.DeepEqual(ids, []int{0, 1, 2, 3, 4, 5, 6}) {
+		t.Errorf("single bot: expected all the rows, got %v", ids)
+	}
+}
+
+func TestFeederPartitionFewerRowsThanBots(t *testing.T) {
+	// bots without a share of rows which are used once get nothing
+	for _, strategy := range []FeederStrategy{"", FeederSequential, FeederUnique} {
+		feeder := Feeder{Strategy: strategy, Rows: feederTestRows(2)}
+		if rows := feeder.partition(2, 3).Rows; len(rows) != 0 {
+			t.Errorf("%v: expected no rows, got %v", strategy, rowIds(rows))
+		}
+	}
+
+	// while rows which are reused can be shared
+	for _, strategy := range []FeederStrategy{FeederCircular, FeederRandom} {
+		feeder := Feeder{Strategy: strategy, Rows: feederTestRows(2)}
+		if ids := rowIds(feeder.partition(2, 3).Rows); !reflect.DeepEqual(ids, []int{0, 1}) {
+			t.Errorf("%v: expected all the rows, got %v", strategy, ids)
+		}
+		if ids := rowIds(feeder.partition(1, 3).Rows); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("%v: expected its own share, got %v", strategy, ids)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// flowStep is a request template, or a group of them, with the control flow
+// around it: an If condition to skip it, and a Repeat count and/or While
+// condition to loop over it.
+type flowStep struct {
+	name     string
+	tmpl     RequestTemplate
+	compiled *compiledTemplate // for requests of sessions
+	cond     *fieldTemplate
+	while    *fieldTemplate
+	repeat   uint
+	group    []*flowStep
+}
+
+// compileFlow compiles the control flow of templates, and with compile, their
+// requests.
+func compileFlow(tmpls []RequestTemplate, parent string, compile bool) ([]*flowStep, error) {
+	steps := make([]*flowStep, len(tmpls))
+	for i, tmpl := range tmpls {
+		step := &flowStep{name: stepName(parent, i, tmpl), tmpl: tmpl, repeat: tmpl.Repeat}
+		step.tmpl.Name = step.name
+
+		var err error
+		if step.cond, err = compileCondition("if", tmpl.If); err != nil {
+			return nil, err
+		}
+		if step.while, err = compileCondition("while", tmpl.While); err != nil {
+			return nil, err
+		}
+
+		if len(tmpl.Group) > 0 {
+			if step.group, err = compileFlow(tmpl.Group, step.name, compile); err != nil {
+				return nil, err
+			}
+		} else if compile {
+			if err := mergeTemplateWithDefaults(&step.tmpl); err != nil {
+				return nil, err
+			}
+			if step.compiled, err = compileTemplate(step.tmpl); err != nil {
+				return nil, err
+			}
+		}
+
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// compileCondition compiles an expression of the template engine, such as
+// `ne .status "done"`, with or without its braces.
+func compileCondition(name string, expr string) (*fieldTemplate, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if !strings.Contains(expr, "{{") {
+		expr = "{{ " + expr + " }}"
+	}
+	field, err := compileField(name, expr, false)
+	return &field, err
+}
+
+// testCondition tells whether a condition renders to a true value, i.e.
+// anything but an empty string, false, 0 or a missing value.
+func testCondition(cond *fieldTemplate, vars Variables) (bool, error) {
+	result, err := cond.render(vars)
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(result) {
+	case "", "false", "0", "<no value>":
+		return false, nil
+	}
+	return true, nil
+}
+
+// skipped tells whether the step's If condition is false.
+func (step *flowStep) skipped(vars Variables) (bool, error) {
+	if step.cond == nil {
+		return false, nil
+	}
+	ok, err := testCondition(step.cond, vars)
+	return !ok, err
+}
+
+// looping tells whether the step may run more than once, in which case the
+// index of its current iteration is available as .iteration.
+func (step *flowStep) looping() bool {
+	return step.repeat > 0 || step.while != nil
+}
+
+// loops tells whether the step runs again after its iteration-th run (from
+// 1): up to Repeat times, and as long as its While condition holds.
+func (step *flowStep) loops(iteration int, vars Variables) (bool, error) {
+	if step.repeat > 0 && uint(iteration) >= step.repeat {
+		return false, nil
+	}
+	if step.while == nil {
+		return step.repeat > 0, nil
+	}
+	return testCondition(step.while, vars)
+}
+
+// transaction records the execution of a group as a whole, so that stats can
+// report the duration of the sub-flow as a transaction.
+func transaction(idx uint, name string, started time.Time, failed string) ResponseInfo {
+	res := ResponseInfo{
+		Idx:         idx,
+		Step:        name,
+		Timestamp:   timestamp(started),
+		Elapsed:     time.Now().Sub(started).Seconds() * 1000,
+		Transaction: true,
+	}
+	if failed != "" {
+		res.Error = fmt.Sprintf("step %v failed", failed)
+	}
+	return res
+}
+
+// execFlowLocally runs the steps of a scenario one after the other, each
+// request template with its own load, and with variables shared by all. It
+// returns the first failed step, if any.
+func execFlowLocally(ctx context.Context, steps []*flowStep, vars *Variables, feeders *feederSet, jars *userJars, out chan ResponseInfo) (string, error) {
+	var failedStep string
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			break
+		}
+		if skip, err := step.skipped(*vars); err != nil || skip {
+			if err != nil {
+				return failedStep, err
+			}
+			continue
+		}
+
+		for iteration := 0; ; iteration++ {
+			if step.looping() {
+				(*vars)["iteration"] = iteration
+			}
+
+			var failed string
+			var err error
+			if step.group != nil {
+				started := time.Now()
+				failed, err = execFlowLocally(ctx, step.group, vars, feeders, jars, out)
+				// an interrupted group didn't really take that long
+				if ctx.Err() == nil {
+					out <- transaction(0, step.name, started, failed)
+				}
+				step.tmpl.ThinkTime.pause(ctx)
+			} else {
+				failed, err = execRequestPlan(ctx, step.tmpl, vars, feeders, jars, out)
+			}
+			if err != nil {
+				return failedStep, err
+			}
+			if failedStep == "" {
+				failedStep = failed
+			}
+
+			again, err := step.loops(iteration+1, *vars)
+			if err != nil {
+				return failedStep, err
+			}
+			if !again || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	return failedStep, nil
+}
@@ -0,0 +1,197 @@
+package req
+
+import (
+	"bytes"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the functions available to the request templates, to
+// generate dynamic data, e.g. {{ uuid }} or {{ randInt 1 100 }}.
+var templateFuncs = template.FuncMap{
+	"uuid":         uuid,
+	"randInt":      randInt,
+	"randString":   randString,
+	"randChoice":   randChoice,
+	"now":          time.Now,
+	"unix":         func() int64 { return time.Now().Unix() },
+	"unixMillis":   func() int64 { return time.Now().UnixNano() / int64(time.Millisecond) },
+	"date":         formatDate,
+	"base64":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"base64Decode": base64Decode,
+	"sha256":       func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"hmac":         hmacSha256,
+	"urlEncode":    url.QueryEscape,
+	"jsonEscape":   jsonEscape,
+	"env":          os.Getenv,
+	"firstName":    func() string { return fakeFirstNames[randIntn(len(fakeFirstNames))] },
+	"lastName":     func() string { return fakeLastNames[randIntn(len(fakeLastNames))] },
+	"fullName":     fakeFullName,
+	"email":        fakeEmail,
+}
+
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randIntn(n int) int {
+	random.Lock()
+	defer random.Unlock()
+	return random.Intn(n)
+}
+
+func randFloat64() float64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.Float64()
+}
+
+func randExpFloat64() float64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.ExpFloat64()
+}
+
+// uuid generates a random (version 4) uuid.
+func uuid() (string, error) {
+	var b [16]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// randInt returns a random integer between min and max, inclusive.
+func randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt: max %v is lower than min %v", max, min)
+	}
+	return min + randIntn(max-min+1), nil
+}
+
+const randStringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// randString returns a random alphanumeric string of length n.
+func randString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randStringChars[randIntn(len(randStringChars))]
+	}
+	return string(b)
+}
+
+// randChoice returns one of its arguments at random, or an element of its
+// argument if it's a single list, e.g. {{ randChoice .skus }}.
+func randChoice(choices ...interface{}) (interface{}, error) {
+	if len(choices) == 1 {
+		list := reflect.ValueOf(choices[0])
+		if list.Kind() == reflect.Slice || list.Kind() == reflect.Array {
+			if list.Len() == 0 {
+				return nil, fmt.Errorf("randChoice: empty list")
+			}
+			return list.Index(randIntn(list.Len())).Interface(), nil
+		}
+	}
+	if len(choices) == 0 {
+		return nil, fmt.Errorf("randChoice: no choices")
+	}
+	return choices[randIntn(len(choices))], nil
+}
+
+var dateLayouts = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"iso8601":  "2006-01-02T15:04:05Z07:00",
+	"rfc1123":  time.RFC1123,
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04:05",
+}
+
+// formatDate formats the current time, or the given one, with a go layout
+// or a named one, e.g. {{ date "2006-01-02" }} or {{ date "rfc3339" now }}.
+func formatDate(layout string, t ...time.Time) string {
+	if named, ok := dateLayouts[layout]; ok {
+		layout = named
+	}
+	if len(t) > 0 {
+		return t[0].Format(layout)
+	}
+	return time.Now().Format(layout)
+}
+
+func base64Decode(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	return string(data), err
+}
+
+// hmacSha256 returns the hex-encoded hmac-sha256 of a message.
+func hmacSha256(key, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// jsonEscape escapes a string to be embedded in a json string.
+func jsonEscape(val interface{}) (string, error) {
+	str := fmt.Sprintf("%v", val)
+	if !needsJsonEscape(str) {
+		return str, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(str); err != nil {
+		return "", err
+	}
+	// strip the quotes and the trailing newline
+	data := buf.Bytes()
+	return string(data[1 : len(data)-2]), nil
+}
+
+func needsJsonEscape(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if c := str[i]; c < 0x20 || c == '"' || c == '\\' || c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+var fakeFirstNames = []string{
+	"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth",
+	"David", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Charles", "Karen",
+	"Ahmed", "Fatima", "Wei", "Mei", "Hiroshi", "Yuki", "Carlos", "Sofia", "Ivan", "Olga",
+}
+
+var fakeLastNames = []string{
+	"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+	"Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin",
+	"Lee", "Wang", "Kim", "Nguyen", "Tanaka", "Silva", "Ivanov", "Mueller", "Dubois", "Rossi",
+}
+
+func fakeFullName() string {
+	return fakeFirstNames[randIntn(len(fakeFirstNames))] + " " + fakeLastNames[randIntn(len(fakeLastNames))]
+}
+
+// fakeEmail returns a random email address, unique enough for sign-ups.
+func fakeEmail() string {
+	first := fakeFirstNames[randIntn(len(fakeFirstNames))]
+	last := fakeLastNames[randIntn(len(fakeLastNames))]
+	return strings.ToLower(fmt.Sprintf("%v.%v.%v@example.com", first, last, randString(6)))
+}
@@ -0,0 +1,208 @@
+package req
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Histogram is a fixed-memory, HDR-style histogram: values are counted in
+// buckets whose width grows with the magnitude of the value, which keeps a
+// fixed number of significant digits whatever the range of the values.
+type Histogram struct {
+	scale         float64 // values are recorded in 1/scale units
+	subBucketBits uint
+	counts        []int64
+	count         int64
+	min           float64
+	max           float64
+	total         float64
+	mean          float64
+	m2            float64 // sum of squared deviations from the mean
+}
+
+// DurationScale records durations in milliseconds with a microsecond
+// resolution.
+const DurationScale = 1000
+
+// NewHistogram creates a histogram recording values with a resolution of
+// 1/scale, and 3 significant digits.
+func NewHistogram(scale float64) *Histogram {
+	return NewHistogramWithPrecision(scale, 3)
+}
+
+// NewHistogramWithPrecision creates a histogram keeping the given number of
+// significant digits. Each digit less divides its memory by about 10.
+func NewHistogramWithPrecision(scale float64, digits int) *Histogram {
+	// enough sub-buckets to tell apart 2 * 10^digits values
+	subBucketBits := uint(math.Ceil(math.Log2(2 * math.Pow10(digits))))
+	return &Histogram{scale: scale, subBucketBits: subBucketBits}
+}
+
+func (h *Histogram) Record(value float64) {
+	h.RecordN(value, 1)
+}
+
+// RecordN records the same value n times.
+func (h *Histogram) RecordN(value float64, n int64) {
+	if n <= 0 {
+		return
+	}
+	if value < 0 {
+		value = 0
+	}
+
+	idx := h.index(int64(value*h.scale + 0.5))
+	if idx >= len(h.counts) {
+		counts := make([]int64, idx+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[idx] += n
+
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	if h.count == 0 || value > h.max {
+		h.max = value
+	}
+
+	// Welford's online algorithm, generalized to n identical values
+	count := h.count + n
+	delta := value - h.mean
+	h.mean += delta * float64(n) / float64(count)
+	h.m2 += delta * (value - h.mean) * float64(n)
+	h.count = count
+	h.total += value * float64(n)
+}
+
+// Merge adds the values recorded by other, which must have the same scale
+// and precision.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	if h.count == 0 {
+		h.min, h.max = other.min, other.max
+	} else {
+		h.min = math.Min(h.min, other.min)
+		h.max = math.Max(h.max, other.max)
+	}
+
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for idx, n := range other.counts {
+		h.counts[idx] += n
+	}
+
+	count := h.count + other.count
+	delta := other.mean - h.mean
+	h.m2 += other.m2 + delta*delta*float64(h.count)*float64(other.count)/float64(count)
+	h.mean += delta * float64(other.count) / float64(count)
+	h.count = count
+	h.total += other.total
+}
+
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+func (h *Histogram) Min() float64 {
+	return h.min
+}
+
+func (h *Histogram) Max() float64 {
+	return h.max
+}
+
+func (h *Histogram) Total() float64 {
+	return h.total
+}
+
+func (h *Histogram) Mean() float64 {
+	return h.mean
+}
+
+func (h *Histogram) StdDev() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return math.Sqrt(h.m2 / float64(h.count))
+}
+
+// Percentile returns the value below which p percent of the values fall.
+func (h *Histogram) Percentile(p float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.min
+	}
+	if p >= 100 {
+		return h.max
+	}
+
+	target := int64(math.Ceil(p / 100 * float64(h.count)))
+	var seen int64
+	for idx, n := range h.counts {
+		seen += n
+		if seen >= target {
+			value := h.value(idx) / h.scale
+			return math.Max(h.min, math.Min(h.max, value))
+		}
+	}
+	return h.max
+}
+
+// BucketCount returns the number of buckets, empty or not. Histograms of the
+// same scale and precision share the same buckets, so their counts can be
+// compared bucket by bucket with CountAt.
+func (h *Histogram) BucketCount() int {
+	return len(h.counts)
+}
+
+// CountAt returns the number of values in the bucket at idx.
+func (h *Histogram) CountAt(idx int) int64 {
+	if idx < 0 || idx >= len(h.counts) {
+		return 0
+	}
+	return h.counts[idx]
+}
+
+// Buckets calls fn for each non-empty bucket, in increasing order of value.
+func (h *Histogram) Buckets(fn func(value float64, count int64)) {
+	for idx, n := range h.counts {
+		if n > 0 {
+			fn(h.value(idx)/h.scale, n)
+		}
+	}
+}
+
+func (h *Histogram) index(value int64) int {
+	subBucketCount := int64(1) << h.subBucketBits
+	subBucketHalf := int(subBucketCount / 2)
+
+	if value < subBucketCount {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - int(h.subBucketBits)
+	subBucket := int(value >> uint(shift))
+	return shift*subBucketHalf + subBucket
+}
+
+// value returns the middle of the bucket's range.
+func (h *Histogram) value(idx int) float64 {
+	subBucketCount := 1 << h.subBucketBits
+	subBucketHalf := subBucketCount / 2
+
+	if idx < subBucketCount {
+		return float64(idx)
+	}
+	shift := idx/subBucketHalf - 1
+	subBucket := idx - shift*subBucketHalf
+	lowest := int64(subBucket) << uint(shift)
+	width := int64(1) << uint(shift)
+	return float64(lowest) + float64(width)/2
+}
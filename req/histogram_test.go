@@ -0,0 +1,91 @@
+package req
+
+import (
+	"math"
+	"testing"
+)
+
+// within tells whether actual is within the relative error of expected.
+func within(actual, expected, relativeError float64) bool {
+	return math.Abs(actual-expected) <= math.Abs(expected)*relativeError
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram(DurationScale)
+	for i := 1; i <= 10000; i++ {
+		h.Record(float64(i) / 10)
+	}
+
+	tests := []struct {
+		p        float64
+		expected float64
+	}{
+		{0, 0.1},
+		{1, 10},
+		{50, 500},
+		{90, 900},
+		{99, 990},
+		{99.9, 999},
+		{100, 1000},
+	}
+	for _, test := range tests {
+		// 3 significant digits
+		if actual := h.Percentile(test.p); !within(actual, test.expected, 0.001) {
+			t.Errorf("p%v: expected %v, got %v", test.p, test.expected, actual)
+		}
+	}
+
+	if h.Count() != 10000 || h.Min() != 0.1 || h.Max() != 1000 || !within(h.Mean(), 500.05, 1e-9) {
+		t.Errorf("unexpected count %v, min %v, max %v or mean %v", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+}
+
+func TestHistogramPercentilesWideRange(t *testing.T) {
+	h := NewHistogram(DurationScale)
+	// mostly fast values, with a slow tail several orders of magnitude higher
+	h.RecordN(2, 980)
+	h.RecordN(30000, 20)
+
+	if p := h.Percentile(50); !within(p, 2, 0.001) {
+		t.Errorf("p50: expected 2, got %v", p)
+	}
+	if p := h.Percentile(98); !within(p, 2, 0.001) {
+		t.Errorf("p98: expected 2, got %v", p)
+	}
+	if p := h.Percentile(99); !within(p, 30000, 0.001) {
+		t.Errorf("p99: expected 30000, got %v", p)
+	}
+}
+
+func TestHistogramPercentilesEmpty(t *testing.T) {
+	h := NewHistogram(DurationScale)
+	for _, p := range []float64{0, 50, 100} {
+		if actual := h.Percentile(p); actual != 0 {
+			t.Errorf("p%v: expected 0, got %v", p, actual)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b, all := NewHistogram(DurationScale), NewHistogram(DurationScale), NewHistogram(DurationScale)
+	for i := 1; i <= 1000; i++ {
+		value := float64(i)
+		if i%3 == 0 {
+			a.Record(value)
+		} else {
+			b.Record(value)
+		}
+		all.Record(value)
+	}
+	a.Merge(b)
+
+	if a.Count() != all.Count() || a.Min() != all.Min() || a.Max() != all.Max() || !within(a.Mean(), all.Mean(), 1e-9) ||
+		!within(a.StdDev(), all.StdDev(), 1e-9) {
+		t.Errorf("expected the merged histogram to match the whole one")
+	}
+	for _, p := range []float64{1, 50, 95, 99.9} {
+		if a.Percentile(p) != all.Percentile(p) {
+			t.Errorf("p%v: expected %v, got %v", p, all.Percentile(p), a.Percentile(p))
+		}
+	}
+}
@@ -0,0 +1,682 @@
+package req
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression, such as "$.items[*].id",
+// "$..name" or "$.items[?(@.price < 10 && @.tags)].name". It supports
+// child and recursive descent, wildcards, indexes (negative ones count from
+// the end), slices, unions and filters.
+type jsonPath struct {
+	expr     string
+	segments []jsonPathSegment
+}
+
+type jsonPathSegment struct {
+	recursive bool
+	selectors []jsonPathSelector
+}
+
+type jsonPathSelector interface {
+	apply(node interface{}, out []interface{}) []interface{}
+}
+
+type jsonPathName string
+type jsonPathWildcard struct{}
+type jsonPathIndex int
+type jsonPathSlice struct {
+	start, end, step *int
+}
+type jsonPathFilter struct {
+	expr filterExpr
+}
+
+func (name jsonPathName) apply(node interface{}, out []interface{}) []interface{} {
+	if obj, ok := node.(map[string]interface{}); ok {
+		if child, ok := obj[string(name)]; ok {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+func (jsonPathWildcard) apply(node interface{}, out []interface{}) []interface{} {
+	return append(out, children(node)...)
+}
+
+func (idx jsonPathIndex) apply(node interface{}, out []interface{}) []interface{} {
+	if arr, ok := node.([]interface{}); ok {
+		i := int(idx)
+		if i < 0 {
+			i += len(arr)
+		}
+		if i >= 0 && i < len(arr) {
+			out = append(out, arr[i])
+		}
+	}
+	return out
+}
+
+func (slice jsonPathSlice) apply(node interface{}, out []interface{}) []interface{} {
+	arr, ok := node.([]interface{})
+	if !ok {
+		return out
+	}
+
+	step := 1
+	if slice.step != nil {
+		step = *slice.step
+	}
+	if step == 0 {
+		return out
+	}
+
+	bound := func(idx *int, dflt int) int {
+		if idx == nil {
+			return dflt
+		}
+		i := *idx
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < -1 {
+			i = -1
+		}
+		if i > len(arr) {
+			i = len(arr)
+		}
+		return i
+	}
+
+	if step > 0 {
+		start, end := bound(slice.start, 0), bound(slice.end, len(arr))
+		if start < 0 {
+			start = 0
+		}
+		for i := start; i < end; i += step {
+			out = append(out, arr[i])
+		}
+	} else {
+		start, end := bound(slice.start, len(arr)-1), bound(slice.end, -1)
+		if start >= len(arr) {
+			start = len(arr) - 1
+		}
+		for i := start; i > end; i += step {
+			out = append(out, arr[i])
+		}
+	}
+	return out
+}
+
+func (filter jsonPathFilter) apply(node interface{}, out []interface{}) []interface{} {
+	for _, child := range children(node) {
+		if filter.expr.test(child) {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+// children returns the values of an array, or of an object in key order.
+func children(node interface{}) []interface{} {
+	switch val := node.(type) {
+	case []interface{}:
+		return val
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		result := make([]interface{}, len(keys))
+		for i, key := range keys {
+			result[i] = val[key]
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+func descendants(node interface{}, out []interface{}) []interface{} {
+	out = append(out, node)
+	for _, child := range children(node) {
+		out = descendants(child, out)
+	}
+	return out
+}
+
+func (path jsonPath) evaluate(root interface{}) []interface{} {
+	nodes := []interface{}{root}
+	for _, segment := range path.segments {
+		var next []interface{}
+		for _, node := range nodes {
+			targets := []interface{}{node}
+			if segment.recursive {
+				targets = descendants(node, nil)
+			}
+			for _, target := range targets {
+				for _, selector := range segment.selectors {
+					next = selector.apply(target, next)
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// jsonPathParser is a recursive descent parser for JSONPath expressions and
+// their filters.
+type jsonPathParser struct {
+	expr string
+	pos  int
+}
+
+func compileJsonPath(expr string) (jsonPath, error) {
+	parser := &jsonPathParser{expr: strings.TrimSpace(expr)}
+	if !parser.consume("$") {
+		return jsonPath{}, parser.errorf("expected '$'")
+	}
+
+	path, err := parser.parsePath()
+	if err != nil {
+		return jsonPath{}, err
+	}
+	if !parser.done() {
+		return jsonPath{}, parser.errorf("unexpected '%v'", parser.expr[parser.pos:])
+	}
+	path.expr = parser.expr
+	return path, nil
+}
+
+func (p *jsonPathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid jsonpath '%v' at position %v: %v", p.expr, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *jsonPathParser) done() bool {
+	return p.pos >= len(p.expr)
+}
+
+func (p *jsonPathParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.expr[p.pos]
+}
+
+func (p *jsonPathParser) skipSpaces() {
+	for !p.done() && p.expr[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *jsonPathParser) consume(token string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// parsePath parses segments until something that can't continue a path.
+func (p *jsonPathParser) parsePath() (jsonPath, error) {
+	var path jsonPath
+	for !p.done() {
+		var segment jsonPathSegment
+		var err error
+
+		switch {
+		case p.consume(".."):
+			segment.recursive = true
+			if p.peek() == '[' {
+				p.pos++
+				segment.selectors, err = p.parseBracket()
+			} else {
+				segment.selectors, err = p.parseDotted()
+			}
+		case p.consume("."):
+			segment.selectors, err = p.parseDotted()
+		case p.consume("["):
+			segment.selectors, err = p.parseBracket()
+		default:
+			return path, nil
+		}
+
+		if err != nil {
+			return path, err
+		}
+		path.segments = append(path.segments, segment)
+	}
+	return path, nil
+}
+
+func (p *jsonPathParser) parseDotted() ([]jsonPathSelector, error) {
+	if p.consume("*") {
+		return []jsonPathSelector{jsonPathWildcard{}}, nil
+	}
+	name := p.parseName()
+	if name == "" {
+		return nil, p.errorf("expected a name")
+	}
+	return []jsonPathSelector{jsonPathName(name)}, nil
+}
+
+func (p *jsonPathParser) parseName() string {
+	start := p.pos
+	for !p.done() {
+		c := p.expr[p.pos]
+		// names inside a filter end with the operators, or with the bracket
+		if strings.IndexByte(".[] ,)=!<>&|", c) >= 0 {
+			break
+		}
+		p.pos++
+	}
+	return p.expr[start:p.pos]
+}
+
+// parseBracket parses the comma-separated selectors of a bracket, whose
+// opening '[' was consumed.
+func (p *jsonPathParser) parseBracket() ([]jsonPathSelector, error) {
+	var selectors []jsonPathSelector
+	for {
+		p.skipSpaces()
+		selector, err := p.parseBracketSelector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+
+		p.skipSpaces()
+		if p.consume("]") {
+			return selectors, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *jsonPathParser) parseBracketSelector() (jsonPathSelector, error) {
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+		return jsonPathWildcard{}, nil
+	case c == '\'' || c == '"':
+		name, err := p.parseString()
+		return jsonPathName(name), err
+	case c == '?':
+		p.pos++
+		expr, err := p.parseFilter()
+		return jsonPathFilter{expr}, err
+	default:
+		return p.parseIndexOrSlice()
+	}
+}
+
+func (p *jsonPathParser) parseIndexOrSlice() (jsonPathSelector, error) {
+	var bounds [3]*int
+	count := 0
+	for count < 3 {
+		p.skipSpaces()
+		if n, ok := p.parseInt(); ok {
+			bounds[count] = &n
+		}
+		count++
+		p.skipSpaces()
+		if !p.consume(":") {
+			break
+		}
+	}
+
+	if count == 1 {
+		if bounds[0] == nil {
+			return nil, p.errorf("expected an index")
+		}
+		return jsonPathIndex(*bounds[0]), nil
+	}
+	return jsonPathSlice{start: bounds[0], end: bounds[1], step: bounds[2]}, nil
+}
+
+func (p *jsonPathParser) parseInt() (int, bool) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for !p.done() && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.expr[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+func (p *jsonPathParser) parseString() (string, error) {
+	quote := p.expr[p.pos]
+	p.pos++
+	var buf []byte
+	for !p.done() {
+		c := p.expr[p.pos]
+		p.pos++
+		switch {
+		case c == '\\' && !p.done():
+			buf = append(buf, p.expr[p.pos])
+			p.pos++
+		case c == quote:
+			return string(buf), nil
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// filterExpr is a filter predicate, e.g. @.price < 10 && @.tags
+type filterExpr interface {
+	test(node interface{}) bool
+}
+
+type filterOr []filterExpr
+type filterAnd []filterExpr
+type filterNot struct{ expr filterExpr }
+type filterExists struct{ operand filterOperand }
+type filterCompare struct {
+	left, right filterOperand
+	op          string
+}
+
+// filterOperand is either a path relative to the current node (@...), or a
+// literal.
+type filterOperand struct {
+	path    *jsonPath
+	literal interface{}
+	regexp  *regexp.Regexp
+}
+
+func (f filterOr) test(node interface{}) bool {
+	for _, expr := range f {
+		if expr.test(node) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f filterAnd) test(node interface{}) bool {
+	for _, expr := range f {
+		if !expr.test(node) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f filterNot) test(node interface{}) bool {
+	return !f.expr.test(node)
+}
+
+func (f filterExists) test(node interface{}) bool {
+	_, ok := f.operand.value(node)
+	return ok
+}
+
+func (operand filterOperand) value(node interface{}) (interface{}, bool) {
+	if operand.path == nil {
+		return operand.literal, true
+	}
+	matches := operand.path.evaluate(node)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	return matches[0], true
+}
+
+func (f filterCompare) test(node interface{}) bool {
+	left, ok := f.left.value(node)
+	if !ok {
+		return false
+	}
+
+	if f.op == "=~" {
+		str, ok := left.(string)
+		return ok && f.right.regexp != nil && f.right.regexp.MatchString(str)
+	}
+
+	right, ok := f.right.value(node)
+	if !ok {
+		return false
+	}
+
+	switch f.op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+
+	cmp, ok := compareValues(left, right)
+	if !ok {
+		return false
+	}
+	switch f.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// compareValues orders two numbers or two strings.
+func compareValues(left, right interface{}) (int, bool) {
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(l, r), true
+	}
+	return 0, false
+}
+
+// parseFilter parses "(expr)" or, without parentheses, a single expression.
+func (p *jsonPathParser) parseFilter() (filterExpr, error) {
+	p.skipSpaces()
+	return p.parseOr()
+}
+
+func (p *jsonPathParser) parseOr() (filterExpr, error) {
+	var exprs filterOr
+	for {
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		p.skipSpaces()
+		if !p.consume("||") {
+			break
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+func (p *jsonPathParser) parseAnd() (filterExpr, error) {
+	var exprs filterAnd
+	for {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		p.skipSpaces()
+		if !p.consume("&&") {
+			break
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return exprs, nil
+}
+
+func (p *jsonPathParser) parseUnary() (filterExpr, error) {
+	p.skipSpaces()
+	if p.consume("!") {
+		expr, err := p.parseUnary()
+		return filterNot{expr}, err
+	}
+	if p.consume("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+var filterOps = []string{"==", "!=", "<=", ">=", "=~", "<", ">"}
+
+func (p *jsonPathParser) parseComparison() (filterExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	for _, op := range filterOps {
+		if p.consume(op) {
+			p.skipSpaces()
+			if op == "=~" {
+				re, err := p.parseRegexp()
+				return filterCompare{left: left, op: op, right: filterOperand{regexp: re}}, err
+			}
+			right, err := p.parseOperand()
+			return filterCompare{left: left, op: op, right: right}, err
+		}
+	}
+
+	if left.path == nil {
+		return nil, p.errorf("expected a comparison")
+	}
+	return filterExists{left}, nil
+}
+
+func (p *jsonPathParser) parseOperand() (filterOperand, error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '@':
+		p.pos++
+		path, err := p.parsePath()
+		return filterOperand{path: &path}, err
+	case c == '\'' || c == '"':
+		str, err := p.parseString()
+		return filterOperand{literal: str}, err
+	case p.consume("true"):
+		return filterOperand{literal: true}, nil
+	case p.consume("false"):
+		return filterOperand{literal: false}, nil
+	case p.consume("null"):
+		return filterOperand{literal: nil}, nil
+	default:
+		return p.parseNumber()
+	}
+}
+
+func (p *jsonPathParser) parseNumber() (filterOperand, error) {
+	start := p.pos
+	for !p.done() && strings.IndexByte("-+.0123456789eE", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+	n, err := strconv.ParseFloat(p.expr[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return filterOperand{}, p.errorf("expected a value")
+	}
+	return filterOperand{literal: n}, nil
+}
+
+// parseRegexp parses /regexp/ or a quoted regexp.
+func (p *jsonPathParser) parseRegexp() (*regexp.Regexp, error) {
+	var expr string
+	switch p.peek() {
+	case '/':
+		end := strings.IndexByte(p.expr[p.pos+1:], '/')
+		if end < 0 {
+			return nil, p.errorf("unterminated regular expression")
+		}
+		expr = p.expr[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	case '\'', '"':
+		var err error
+		if expr, err = p.parseString(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.errorf("expected a regular expression")
+	}
+	return compileRegexp(expr)
+}
+
+// definite tells whether the path selects at most one node: it has only
+// names and indexes, and no recursive descent.
+func (path jsonPath) definite() bool {
+	for _, segment := range path.segments {
+		if segment.recursive || len(segment.selectors) != 1 {
+			return false
+		}
+		switch segment.selectors[0].(type) {
+		case jsonPathName, jsonPathIndex:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// query evaluates the path. A definite path yields the value of its match,
+// and any other path yields its matches as a list, even when there is only
+// one, so that the shape of the result doesn't depend on the data.
+func (path jsonPath) query(object interface{}) (interface{}, error) {
+	matches := path.evaluate(object)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no match for '%v'", path.expr)
+	}
+	if path.definite() {
+		return matches[0], nil
+	}
+	return matches, nil
+}
@@ -0,0 +1,111 @@
+package req
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const jsonPathTestDoc = `{
+	"name": "shop",
+	"items": [
+		{"id": 1, "name": "hat", "price": 8, "tags": ["new"], "inStock": true},
+		{"id": 2, "name": "shoes", "price": 60, "inStock": false},
+		{"id": 3, "name": "socks", "price": 5, "tags": [], "inStock": true},
+		{"id": 4, "name": "scarf", "price": 12.5, "inStock": true}
+	],
+	"owner": {"name": "jane", "address": {"city": "Paris"}}
+}`
+
+func parseJsonPathTestDoc(t *testing.T) interface{} {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(jsonPathTestDoc), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestJsonPath(t *testing.T) {
+	doc := parseJsonPathTestDoc(t)
+
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		// selectors
+		{"$.name", "shop"},
+		{"$['name']", "shop"},
+		{`$["owner"]["address"].city`, "Paris"},
+		{"$.items[*].id", []interface{}{1.0, 2.0, 3.0, 4.0}},
+		{"$.items[0,2].name", []interface{}{"hat", "socks"}},
+		{"$..city", []interface{}{"Paris"}},
+		{"$..address.city", []interface{}{"Paris"}},
+		{"$.owner.*", []interface{}{map[string]interface{}{"city": "Paris"}, "jane"}},
+		// indexes and slices
+		{"$.items[1:2].id", []interface{}{2.0}},
+		{"$.items[0,5].id", []interface{}{1.0}},
+		{"$.items[1].name", "shoes"},
+		{"$.items[-1].name", "scarf"},
+		{"$.items[-2].id", 3.0},
+		{"$.items[1:3].id", []interface{}{2.0, 3.0}},
+		{"$.items[:2].id", []interface{}{1.0, 2.0}},
+		{"$.items[-2:].id", []interface{}{3.0, 4.0}},
+		{"$.items[::2].id", []interface{}{1.0, 3.0}},
+		{"$.items[::-1].id", []interface{}{4.0, 3.0, 2.0, 1.0}},
+		{"$.items[2:0:-1].id", []interface{}{3.0, 2.0}},
+		// filters
+		{"$.items[?(@.price < 10)].id", []interface{}{1.0, 3.0}},
+		{"$.items[?(@.price >= 12.5)].id", []interface{}{2.0, 4.0}},
+		{"$.items[?(@.price < 10 && @.tags)].id", []interface{}{1.0, 3.0}},
+		{"$.items[?(@.price > 50 || @.name == 'hat')].id", []interface{}{1.0, 2.0}},
+		{"$.items[?(!@.tags)].name", []interface{}{"shoes", "scarf"}},
+		{"$.items[?(@.inStock == false)].name", []interface{}{"shoes"}},
+		{"$.items[?(@.name != 'hat')].id", []interface{}{2.0, 3.0, 4.0}},
+		{"$.items[?(@.name =~ /^s.*s$/)].id", []interface{}{2.0, 3.0}},
+		{"$.items[?(@.price < 10 && (@.name == 'hat' || @.name == 'scarf'))].id", []interface{}{1.0}},
+		{"$..[?(@.city == 'Paris')].city", []interface{}{"Paris"}},
+		// filters without parentheses
+		{"$.items[?@.tags].id", []interface{}{1.0, 3.0}},
+		{"$.items[?@.price>50].name", []interface{}{"shoes"}},
+		{"$.items[?@.tags,0].id", []interface{}{1.0, 3.0, 1.0}},
+	}
+
+	for _, test := range tests {
+		path, err := compileJsonPath(test.expr)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		actual, err := path.query(doc)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%v: expected %#v, got %#v", test.expr, test.expected, actual)
+		}
+	}
+}
+
+func TestJsonPathNoMatch(t *testing.T) {
+	doc := parseJsonPathTestDoc(t)
+
+	for _, expr := range []string{"$.missing", "$.items[10]", "$.items[-10]", "$.items[?(@.price > 100)]", "$.name[0]"} {
+		path, err := compileJsonPath(expr)
+		if err != nil {
+			t.Errorf("%v: %v", expr, err)
+			continue
+		}
+		if actual, err := path.query(doc); err == nil {
+			t.Errorf("%v: expected no match, got %#v", expr, actual)
+		}
+	}
+}
+
+func TestJsonPathInvalid(t *testing.T) {
+	for _, expr := range []string{"", "items", "$.", "$.items[", "$.items[?(@.price <)]", "$.items[?(@.price < 10]", "$.items['id]", "$.items[a]", "$.items[?(@.name =~ /x)]"} {
+		if _, err := compileJsonPath(expr); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}
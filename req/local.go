@@ -2,15 +2,15 @@ package req
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
-const maxRetries = 3
-
 type AuthScheme string
 
 const (
@@ -18,95 +18,302 @@ const (
 )
 
 type RequestInfo struct {
-	Idx        uint `json:"idx"`
-	Url        string
-	Method     string
-	Auth       string
-	AuthScheme AuthScheme
-	Headers    map[string]string
-	Body       string
-	Captures   []ResponseCapture
+	Idx             uint `json:"idx"`
+	Name            string
+	Url             string
+	Method          string
+	Auth            string
+	AuthScheme      AuthScheme
+	Headers         map[string]string
+	Body            string
+	Captures        []ResponseCapture
+	Checks          []ResponseCheck
+	FollowRedirects bool
+	MaxRedirects    uint
+	Retry           *RetryPolicy
+	Timeout         Timeouts
+	Scheduled       time.Time  `json:"-"`
+	Jar             *cookieJar `json:"-"`
 }
 
-func execScenarioLocally(scenario RequestScenario, chans execChans) {
+func execScenarioLocally(ctx context.Context, scenario RequestScenario, chans execChans) {
+	if scenario.Sessions != nil {
+		execSessionsLocally(ctx, scenario, chans)
+		return
+	}
+	if scenario.Mix != nil {
+		execMixLocally(ctx, scenario, chans)
+		return
+	}
+
 	vars := scenario.Init
 	if vars == nil {
 		vars = Variables{}
 	}
 
 	go func() {
-		for _, tmpl := range scenario.Requests {
-			if err := execRequestPlan(tmpl, &vars, chans.Out); err != nil {
-				chans.Errs <- err
-			}
+		// Execute drains the channels until done, even after an error
+		defer func() { chans.Done <- true }()
+
+		feeders, err := newFeederSet(scenario.Feeders)
+		if err != nil {
+			chans.Errs <- err
+			return
 		}
 
-		chans.Done <- true
-	}()
+		steps, err := compileFlow(scenario.Requests, "", false)
+		if err != nil {
+			chans.Errs <- err
+			return
+		}
 
+		jars := newUserJars(scenario.Options.Cookies)
+		if _, err := execFlowLocally(ctx, steps, &vars, feeders, jars, chans.Out); err != nil {
+			chans.Errs <- err
+		}
+	}()
 }
 
-func execRequestPlan(tmpl RequestTemplate, vars *Variables, out chan ResponseInfo) error {
-	requests, err := generateRequestBatches(tmpl, *vars)
+// execRequestPlan runs the requests of a template, and returns its name if
+// any of them failed.
+func execRequestPlan(ctx context.Context, tmpl RequestTemplate, vars *Variables, feeders *feederSet, jars *userJars, out chan ResponseInfo) (string, error) {
+	if err := mergeTemplateWithDefaults(&tmpl); err != nil {
+		return "", err
+	}
+
+	gen, err := newRequestGenerator(tmpl, *vars, feeders)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	for res := range execRequests(requests) {
+	profile := newLoadProfile(tmpl.Concurrency, tmpl.Rate, tmpl.Stages)
+
+	var results chan ResponseInfo
+	var errs chan error
+	if profile.rated {
+		results, errs = execRequestsAtRate(ctx, gen, profile, tmpl.MaxInFlight, jars)
+	} else {
+		results, errs = execRequests(ctx, gen, profile, tmpl.ThinkTime, jars)
+	}
+	failed := ""
+	for res := range results {
 		out <- res
 		*vars = mergeVariables(*vars, res.Variables)
+		if res.Failed() {
+			failed = tmpl.Name
+		}
 	}
 
-	return nil
+	return failed, <-errs
 }
 
-func execRequests(batches [][]RequestInfo) chan ResponseInfo {
+// execRequests runs the requests on a pool of long-lived workers (virtual
+// users), each pulling the next request as soon as its previous one
+// completes, so that the concurrency stays at its target even when some
+// requests are slow. Workers beyond the profile's current concurrency idle,
+// and each worker pauses for the think time after each of its requests.
+func execRequests(ctx context.Context, gen *requestGenerator, profile loadProfile, think ThinkTime, jars *userJars) (chan ResponseInfo, chan error) {
 	outCh := make(chan ResponseInfo)
+	errCh := make(chan error, 1)
+	pull := &requestPuller{gen: gen, exhausted: make(chan bool)}
+
 	go func() {
-		for _, batch := range batches {
-			for res := range execParallelRequests(batch) {
-				outCh <- res
-			}
+		var wg sync.WaitGroup
+		for id := uint(0); id < profile.maxConcurrency(); id++ {
+			wg.Add(1)
+			go func(id uint) {
+				defer wg.Done()
+				execWorker(ctx, id, pull, profile, think, jars.worker(id), outCh)
+			}(id)
 		}
+		wg.Wait()
+		errCh <- pull.err
 		close(outCh)
 	}()
 
-	return outCh
+	return outCh, errCh
+}
+
+// requestPuller lets the workers take turns at the generator, so that each
+// renders its requests with its own cookies.
+type requestPuller struct {
+	sync.Mutex
+	gen       *requestGenerator
+	exhausted chan bool
+	ended     bool
+	err       error
+}
+
+// next renders the next request of a worker. ok is false once the generator
+// is exhausted or failed.
+func (pull *requestPuller) next(jar *cookieJar) (RequestInfo, bool) {
+	pull.Lock()
+	defer pull.Unlock()
+	if pull.ended {
+		return RequestInfo{}, false
+	}
+	req, ok, err := pull.gen.next(jar)
+	if !ok {
+		pull.end(err)
+	}
+	return req, ok
+}
+
+// done tells whether the generator is exhausted, for idle workers: a stage
+// may leave no worker to pull until the generator's duration elapses.
+func (pull *requestPuller) done() bool {
+	pull.Lock()
+	defer pull.Unlock()
+	if !pull.ended && pull.gen.done() {
+		pull.end(nil)
+	}
+	return pull.ended
 }
 
-func execParallelRequests(reqs []RequestInfo) chan ResponseInfo {
-	out := make(chan ResponseInfo)
-	in := make(chan ResponseInfo)
+func (pull *requestPuller) end(err error) {
+	pull.ended = true
+	pull.err = err
+	close(pull.exhausted)
+}
 
-	for _, req := range reqs {
-		go func(req RequestInfo) {
-			res, err := execRequest(req)
-			if err != nil {
-				log.Printf("*** ERROR *** Unable to execute request: %v\n", err)
+func execWorker(ctx context.Context, id uint, pull *requestPuller, profile loadProfile, think ThinkTime, jar *cookieJar, out chan ResponseInfo) {
+	for ctx.Err() == nil {
+		if id >= profile.concurrencyAt(time.Now()) {
+			if pull.done() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case <-pull.exhausted:
+			case <-time.After(idleStep):
 			}
-			in <- res
-		}(req)
+			continue
+		}
+
+		req, ok := pull.next(jar)
+		if !ok {
+			return
+		}
+		if res, ok := execRequestAndLog(ctx, req); ok {
+			out <- res
+		}
+		think.pause(ctx)
 	}
+}
+
+// execRequestsAtRate dispatches requests on a fixed schedule, regardless of
+// how long the previous ones take to complete (open model). Each request
+// remembers when it was meant to start, so that a server which falls behind
+// shows up as latency rather than as a lower request rate. At most
+// maxInFlight requests run at once, so that a server which stops responding
+// doesn't pile up connections and goroutines without bound: an arrival
+// waits for a request to complete, and its wait counts as schedule lag.
+func execRequestsAtRate(ctx context.Context, gen *requestGenerator, profile loadProfile, maxInFlight uint, jars *userJars) (chan ResponseInfo, chan error) {
+	outCh := make(chan ResponseInfo)
+	errCh := make(chan error, 1)
+	inFlight := make(chan bool, maxInFlight)
 
 	go func() {
-		defer close(out)
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(outCh)
+		}()
+
+		scheduled := profile.started
+		if profile.rateAt(scheduled) <= 0 {
+			scheduled = profile.nextArrival(scheduled)
+		}
 
-		for range reqs {
-			res := <-in
-			out <- res
+		for {
+			if !sleep(ctx, scheduled.Sub(time.Now())) {
+				errCh <- nil
+				return
+			}
+
+			select {
+			case inFlight <- true:
+			case <-ctx.Done():
+				errCh <- nil
+				return
+			}
+
+			req, ok, err := gen.next(jars.arrival())
+			if err != nil || !ok {
+				errCh <- err
+				return
+			}
+			req.Scheduled = scheduled
+
+			wg.Add(1)
+			go func(req RequestInfo) {
+				defer func() {
+					<-inFlight
+					wg.Done()
+				}()
+				if res, ok := execRequestAndLog(ctx, req); ok {
+					outCh <- res
+				}
+			}(req)
+
+			scheduled = profile.nextArrival(scheduled)
 		}
 	}()
 
-	return out
+	return outCh, errCh
 }
 
-func execRequest(reqInfo RequestInfo) (ResponseInfo, error) {
+// execRequestAndLog executes a request, and tells whether its response
+// should be recorded: a request interrupted because the run was stopped,
+// e.g. when its deadline passed, didn't really fail.
+func execRequestAndLog(ctx context.Context, req RequestInfo) (ResponseInfo, bool) {
+	res, err := execRequest(ctx, req)
+	if err != nil {
+		if requestContext(ctx).Err() != nil {
+			return res, false
+		}
+		log.Printf("*** ERROR *** Unable to execute request: %v\n", err)
+	}
+	return res, true
+}
+
+// execRequest executes a request, and retries it according to its policy.
+// A retried request reports the number of attempts it took, and the time
+// from its first attempt to its last response.
+func execRequest(ctx context.Context, reqInfo RequestInfo) (ResponseInfo, error) {
+	if reqInfo.Retry == nil {
+		result := execAttempt(ctx, reqInfo)
+		return result.res, result.err
+	}
+
+	started := time.Now()
+	for attempt := 1; ; attempt++ {
+		result := execAttempt(ctx, reqInfo)
+		retry := result.condition != "" && reqInfo.Retry.retriesOn(result.condition)
+		if !retry || attempt >= reqInfo.Retry.maxAttempts() || ctx.Err() != nil {
+			res := result.res
+			res.Attempts = uint(attempt)
+			if attempt > 1 {
+				res.Timestamp = timestamp(started)
+				res.Elapsed = time.Now().Sub(started).Seconds() * 1000
+				setScheduleLag(&res, reqInfo, started)
+			}
+			return res, result.err
+		}
+		sleep(ctx, reqInfo.Retry.delay(attempt, result.retryAfter))
+	}
+}
+
+func execAttempt(ctx context.Context, reqInfo RequestInfo) attemptResult {
 	started := time.Now()
 
+	ctx, cancel := withTimeouts(requestContext(ctx), reqInfo.Timeout)
+	defer cancel()
+
 	reqBody := bytes.NewBufferString(reqInfo.Body)
-	req, err := http.NewRequest(reqInfo.Method, reqInfo.Url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, reqInfo.Method, reqInfo.Url, reqBody)
 	if err != nil {
-		return badResponse(reqInfo, fmt.Errorf("Error creating request %#v: %v", reqInfo, err))
+		return failedAttempt(reqInfo, nil, fmt.Errorf("Error creating request %#v: %v", reqInfo, err))
 	}
 
 	for name, val := range reqInfo.Headers {
@@ -116,47 +323,75 @@ func execRequest(reqInfo RequestInfo) (ResponseInfo, error) {
 	if reqInfo.Auth != "" {
 		err = addAuthHeaders(req, reqInfo)
 		if err != nil {
-			return badResponse(reqInfo, fmt.Errorf("Error creating request %#v: %v", reqInfo, err))
+			return failedAttempt(reqInfo, nil, fmt.Errorf("Error creating request %#v: %v", reqInfo, err))
 		}
 	}
 
-	resp, err := transport.RoundTrip(req)
+	resp, hopStarted, timings, redirects, err := roundTrip(transport, req, reqInfo)
 	if err != nil {
-		return badResponse(reqInfo, fmt.Errorf("Error executing request %#v: %v", reqInfo, err))
+		err = requestError(ctx, err)
+		return failedAttempt(reqInfo, err, fmt.Errorf("Error executing request %#v: %v", reqInfo, err))
 	}
 	defer resp.Body.Close()
 
 	connElapsed := time.Now().Sub(started)
-	bodyInfo, err := parseResponseBody(resp, reqInfo.Captures)
+	bodyInfo, err := parseResponseBody(resp, reqInfo.Captures, reqInfo.Checks)
 	if err != nil {
-		return badResponse(reqInfo, fmt.Errorf("Error reading response %#v of request %#v: %v", resp, reqInfo, err))
+		err = requestError(ctx, err)
+		return failedAttempt(reqInfo, err, fmt.Errorf("Error reading response %#v of request %#v: %v", resp, reqInfo, err))
 	}
 
 	elapsed := connElapsed + bodyInfo.Elapsed
 
 	resInfo := ResponseInfo{
 		Idx:        reqInfo.Idx,
+		Step:       reqInfo.Name,
 		Url:        reqInfo.Url,
-		Timestamp:  started.Unix(),
+		Timestamp:  timestamp(started),
 		Elapsed:    elapsed.Seconds() * 1000,
+		BodyMs:     bodyInfo.Elapsed.Seconds() * 1000,
 		Length:     bodyInfo.Length,
 		StatusCode: resp.StatusCode,
+		Redirects:  redirects,
 		Variables:  bodyInfo.Variables,
 	}
+	timings.apply(&resInfo, hopStarted)
+	setScheduleLag(&resInfo, reqInfo, started)
 
-	return resInfo, nil
+	latency := time.Duration(resInfo.EffectiveLatency() * float64(time.Millisecond))
+	resInfo.Checks = runChecks(reqInfo.Checks, resp, bodyInfo, latency)
+	resInfo.StatusCheck = hasStatusCheck(reqInfo.Checks)
+
+	return attemptResult{res: resInfo, condition: statusCondition(resp), retryAfter: parseRetryAfter(resp)}
 }
 
 func badResponse(reqInfo RequestInfo, err error) (ResponseInfo, error) {
 	resInfo := ResponseInfo{
+		Idx:       reqInfo.Idx,
+		Step:      reqInfo.Name,
 		Url:       reqInfo.Url,
-		Timestamp: time.Now().Unix(),
+		Timestamp: timestamp(time.Now()),
 		Error:     err.Error(),
 	}
 
 	return resInfo, err
 }
 
+// setScheduleLag records how late the request started compared to its
+// schedule, and the latency as measured from the scheduled start.
+func setScheduleLag(resInfo *ResponseInfo, reqInfo RequestInfo, started time.Time) {
+	if reqInfo.Scheduled.IsZero() {
+		return
+	}
+
+	lag := started.Sub(reqInfo.Scheduled)
+	if lag < 0 {
+		lag = 0
+	}
+	resInfo.ScheduleLag = lag.Seconds() * 1000
+	resInfo.Latency = resInfo.ScheduleLag + resInfo.Elapsed
+}
+
 func addAuthHeaders(req *http.Request, reqInfo RequestInfo) error {
 	switch reqInfo.AuthScheme {
 	case AuthSchemeBasic:
@@ -0,0 +1,139 @@
+package req
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	benchConcurrency = 16
+	// benchRequests is the number of requests each executor sends, so that
+	// their throughputs compare
+	benchRequests = 960
+)
+
+// newJitteryServer responds after a random delay, mostly short but
+// occasionally much longer, like a real server under load. A batch of
+// benchConcurrency requests almost always has a slow one to wait for.
+func newJitteryServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay := time.Millisecond
+		if rand.Intn(10) == 0 {
+			delay = 40 * time.Millisecond
+		}
+		time.Sleep(delay)
+		w.Write([]byte("ok"))
+	}))
+}
+
+func newBenchGenerator(b *testing.B, url string) (*requestGenerator, RequestTemplate) {
+	tmpl := RequestTemplate{
+		Url:         url,
+		Count:       benchRequests / benchConcurrency,
+		Concurrency: benchConcurrency,
+	}
+	if err := mergeTemplateWithDefaults(&tmpl); err != nil {
+		b.Fatal(err)
+	}
+
+	feeders, err := newFeederSet(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	gen, err := newRequestGenerator(tmpl, Variables{}, feeders)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return gen, tmpl
+}
+
+// execBatchedRequests is the former executor: batches of Concurrency
+// requests, each waiting for its slowest request before the next one starts.
+func execBatchedRequests(gen *requestGenerator, concurrency int) chan ResponseInfo {
+	out := make(chan ResponseInfo)
+
+	go func() {
+		defer close(out)
+		for {
+			batch := make([]RequestInfo, 0, concurrency)
+			for len(batch) < concurrency {
+				req, ok, _ := gen.next(nil)
+				if !ok {
+					break
+				}
+				batch = append(batch, req)
+			}
+			if len(batch) == 0 {
+				return
+			}
+
+			in := make(chan ResponseInfo)
+			for _, req := range batch {
+				go func(req RequestInfo) {
+					res, _ := execRequestAndLog(context.Background(), req)
+					in <- res
+				}(req)
+			}
+			for range batch {
+				out <- <-in
+			}
+		}
+	}()
+
+	return out
+}
+
+// measureThroughput runs the requests of a new generator with exec, and
+// returns their throughput.
+func measureThroughput(b *testing.B, url string, exec func(gen *requestGenerator, tmpl RequestTemplate) chan ResponseInfo) float64 {
+	gen, tmpl := newBenchGenerator(b, url)
+	started := time.Now()
+
+	count := 0
+	for res := range exec(gen, tmpl) {
+		if res.Error != "" {
+			b.Fatal(res.Error)
+		}
+		count++
+	}
+	if count != benchRequests {
+		b.Fatalf("expected %v responses, got %v", benchRequests, count)
+	}
+	return float64(count) / time.Now().Sub(started).Seconds()
+}
+
+// BenchmarkWorkerPool compares the throughput of the worker pool with the
+// former batches, for the same requests to a jittery server: the workers
+// don't wait for the slowest request of each batch.
+func BenchmarkWorkerPool(b *testing.B) {
+	server := newJitteryServer()
+	defer server.Close()
+
+	var pool, batches float64
+	for i := 0; i < b.N; i++ {
+		pool += measureThroughput(b, server.URL, func(gen *requestGenerator, tmpl RequestTemplate) chan ResponseInfo {
+			results, errs := execRequests(context.Background(), gen, newLoadProfile(tmpl.Concurrency, tmpl.Rate, tmpl.Stages), tmpl.ThinkTime, newUserJars(false))
+			go func() {
+				if err := <-errs; err != nil {
+					b.Error(err)
+				}
+			}()
+			return results
+		})
+		batches += measureThroughput(b, server.URL, func(gen *requestGenerator, tmpl RequestTemplate) chan ResponseInfo {
+			return execBatchedRequests(gen, int(tmpl.Concurrency))
+		})
+	}
+
+	speedup := pool / batches
+	b.ReportMetric(pool/float64(b.N), "pool-req/s")
+	b.ReportMetric(batches/float64(b.N), "batch-req/s")
+	b.ReportMetric(speedup, "speedup")
+	if speedup < 2 {
+		b.Errorf("expected the worker pool to be much faster than batches, got a speedup of %.2f", speedup)
+	}
+}
@@ -0,0 +1,130 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Mix runs the request templates of a scenario as a weighted traffic mix
+// rather than one after the other: each request is one of the templates,
+// picked at random according to its Weight (1 by default), so that e.g. a
+// template of weight 70 makes about 70% of the requests of a mix whose
+// weights add up to 100. A template of weight 0 is left out of the mix.
+//
+// Count, Concurrency, Duration, Rate, MaxInFlight, Stages and ThinkTime
+// shape the load of the whole mix, as they do for a single template, and
+// replace those of the templates. The requests of a mix share the Init variables and the feeders,
+// but don't see each other's captures.
+type Mix struct {
+	Count       uint
+	Concurrency uint
+	Duration    Duration
+	Rate        Rate
+	MaxInFlight uint
+	Stages      []Stage
+	ThinkTime   ThinkTime
+	StartIdx    uint
+	IdxStride   uint
+}
+
+var mixDefaults = Mix{
+	Count:       1,
+	Concurrency: 1,
+	MaxInFlight: 1000,
+	IdxStride:   1,
+}
+
+func mergeMixWithDefaults(mix *Mix) error {
+	return mergeLoadWithDefaults(mix, mixDefaults, mix.Stages, &mix.Duration, &mix.Count)
+}
+
+// newMixGenerator renders the requests of a mix, with the templates of the
+// scenario.
+func newMixGenerator(scenario RequestScenario, mix Mix, feeders *feederSet) (*requestGenerator, error) {
+	gen := &requestGenerator{
+		vars:      mergeVariables(scenario.Init),
+		feeders:   feeders,
+		startIdx:  mix.StartIdx,
+		idxStride: mix.IdxStride,
+		total:     mix.Count * mix.Concurrency,
+	}
+	if mix.Duration > 0 {
+		gen.deadline = time.Now().Add(time.Duration(mix.Duration))
+	}
+
+	total := uint(0)
+	for i, tmpl := range scenario.Requests {
+		if len(tmpl.Group) > 0 {
+			return nil, fmt.Errorf("group %v can't be part of a mix", stepName("", i, tmpl))
+		}
+
+		weight := uint(1)
+		if tmpl.Weight != nil {
+			weight = *tmpl.Weight
+		}
+		if weight == 0 {
+			continue
+		}
+
+		tmpl.Name = stepName("", i, tmpl)
+		if err := mergeTemplateWithDefaults(&tmpl); err != nil {
+			return nil, err
+		}
+		compiled, err := compileTemplate(tmpl)
+		if err != nil {
+			return nil, err
+		}
+
+		total += weight
+		gen.tmpls = append(gen.tmpls, compiled)
+		gen.weights = append(gen.weights, total)
+	}
+	if len(gen.tmpls) == 0 {
+		return nil, fmt.Errorf("the mix has no request templates of non-zero weight")
+	}
+
+	return gen, nil
+}
+
+func execMixLocally(ctx context.Context, scenario RequestScenario, chans execChans) {
+	go func() {
+		defer func() { chans.Done <- true }()
+		if err := execMixPlan(ctx, scenario, chans.Out); err != nil {
+			chans.Errs <- err
+		}
+	}()
+}
+
+func execMixPlan(ctx context.Context, scenario RequestScenario, out chan ResponseInfo) error {
+	mix := *scenario.Mix
+	if err := mergeMixWithDefaults(&mix); err != nil {
+		return err
+	}
+
+	feeders, err := newFeederSet(scenario.Feeders)
+	if err != nil {
+		return err
+	}
+
+	gen, err := newMixGenerator(scenario, mix, feeders)
+	if err != nil {
+		return err
+	}
+	jars := newUserJars(scenario.Options.Cookies)
+
+	profile := newLoadProfile(mix.Concurrency, mix.Rate, mix.Stages)
+
+	var results chan ResponseInfo
+	var errs chan error
+	if profile.rated {
+		results, errs = execRequestsAtRate(ctx, gen, profile, mix.MaxInFlight, jars)
+	} else {
+		results, errs = execRequests(ctx, gen, profile, mix.ThinkTime, jars)
+	}
+	for res := range results {
+		out <- res
+	}
+
+	return <-errs
+}
@@ -10,6 +10,11 @@ type Options struct {
 	Debug    bool
 	Insecure bool
 	Pretty   bool
+	Cookies  bool // keep the cookies set by responses, per virtual user
+
+	// GracePeriod is how long the requests in flight have to complete when
+	// the run is stopped, e.g. with Ctrl-C.
+	GracePeriod Duration
 }
 
 var options = Options{
@@ -19,7 +24,7 @@ var options = Options{
 
 func setOptions(o Options) error {
 	if err := mergo.Merge(&options, o); err != nil {
-		return fmt.Errorf("Failed to set options '%#v'", o, err)
+		return fmt.Errorf("Failed to set options '%#v': %v", o, err)
 	}
 
 	if options.Insecure {
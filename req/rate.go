@@ -0,0 +1,123 @@
+package req
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a request arrival rate, such as 500 requests per second. It is
+// encoded in json as "<count>/<unit>", e.g. "500/s", "30/m" or "10/100ms".
+// A plain number is read as a number of requests per second.
+type Rate struct {
+	Count float64
+	Per   time.Duration
+}
+
+func ParseRate(str string) (Rate, error) {
+	parts := strings.SplitN(strings.TrimSpace(str), "/", 2)
+
+	count, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || count < 0 {
+		return Rate{}, fmt.Errorf("invalid rate '%v': expected <count>/<unit>", str)
+	}
+
+	per := time.Second
+	if len(parts) == 2 {
+		per, err = parseRateUnit(parts[1])
+		if err != nil {
+			return Rate{}, fmt.Errorf("invalid rate '%v': %v", str, err)
+		}
+	}
+
+	return Rate{Count: count, Per: per}, nil
+}
+
+func parseRateUnit(unit string) (time.Duration, error) {
+	switch unit {
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+
+	per, err := time.ParseDuration(unit)
+	if err != nil {
+		return 0, err
+	}
+	if per <= 0 {
+		return 0, fmt.Errorf("unit must be positive")
+	}
+	return per, nil
+}
+
+func (r Rate) IsZero() bool {
+	return r.Count <= 0 || r.Per <= 0
+}
+
+func (r Rate) PerSecond() float64 {
+	if r.IsZero() {
+		return 0
+	}
+	return r.Count * float64(time.Second) / float64(r.Per)
+}
+
+// Scale returns the rate multiplied by factor.
+func (r Rate) Scale(factor float64) Rate {
+	return Rate{Count: r.Count * factor, Per: r.Per}
+}
+
+func (r Rate) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	count := strconv.FormatFloat(r.Count, 'f', -1, 64)
+	switch r.Per {
+	case time.Second:
+		return count + "/s"
+	case time.Minute:
+		return count + "/m"
+	case time.Hour:
+		return count + "/h"
+	default:
+		return count + "/" + r.Per.String()
+	}
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch val := raw.(type) {
+	case float64:
+		*r = Rate{Count: val, Per: time.Second}
+	case string:
+		if val == "" {
+			*r = Rate{}
+			return nil
+		}
+		parsed, err := ParseRate(val)
+		if err != nil {
+			return err
+		}
+		*r = parsed
+	case nil:
+		*r = Rate{}
+	default:
+		return fmt.Errorf("invalid rate %v", string(data))
+	}
+
+	return nil
+}
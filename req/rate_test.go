@@ -0,0 +1,65 @@
+package req
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRateJson(t *testing.T) {
+	tests := []struct {
+		json      string
+		expected  Rate
+		perSecond float64
+	}{
+		{`"500/s"`, Rate{500, time.Second}, 500},
+		{`"30/m"`, Rate{30, time.Minute}, 0.5},
+		{`"3600/h"`, Rate{3600, time.Hour}, 1},
+		{`"10/100ms"`, Rate{10, 100 * time.Millisecond}, 100},
+		{`"5/ms"`, Rate{5, time.Millisecond}, 5000},
+		{`" 20 "`, Rate{20, time.Second}, 20},
+		{`2.5`, Rate{2.5, time.Second}, 2.5},
+		{`""`, Rate{}, 0},
+		{`null`, Rate{}, 0},
+	}
+
+	for _, test := range tests {
+		var rate Rate
+		if err := json.Unmarshal([]byte(test.json), &rate); err != nil {
+			t.Errorf("%v: %v", test.json, err)
+			continue
+		}
+		if rate != test.expected {
+			t.Errorf("%v: expected %#v, got %#v", test.json, test.expected, rate)
+		}
+		if rate.PerSecond() != test.perSecond {
+			t.Errorf("%v: expected %v/s, got %v/s", test.json, test.perSecond, rate.PerSecond())
+		}
+	}
+}
+
+func TestRateJsonInvalid(t *testing.T) {
+	for _, data := range []string{`"x/s"`, `"-1/s"`, `"5/0s"`, `"5/-1s"`, `"5/parsec"`, `"5/"`, `true`, `[5]`} {
+		var rate Rate
+		if err := json.Unmarshal([]byte(data), &rate); err == nil {
+			t.Errorf("%v: expected an error, got %#v", data, rate)
+		}
+	}
+}
+
+func TestRateJsonRoundTrip(t *testing.T) {
+	for _, rate := range []Rate{{500, time.Second}, {30, time.Minute}, {1.5, time.Hour}, {10, 100 * time.Millisecond}, {}} {
+		data, err := json.Marshal(rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Rate
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Errorf("%v: %v", string(data), err)
+			continue
+		}
+		if decoded != rate {
+			t.Errorf("%v: expected %#v, got %#v", string(data), rate, decoded)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package req
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const defaultMaxRedirects = 10
+
+// maxDrainedBody is how much of a redirect's body is read to reuse its
+// connection, rather than closing it.
+const maxDrainedBody = 4 << 10
+
+// RedirectHop is a redirect response that was followed.
+type RedirectHop struct {
+	Url        string  `json:"url"`
+	StatusCode int     `json:"statusCode"`
+	Elapsed    float64 `json:"elapsed"`
+}
+
+// roundTrip sends a request through rt, following its redirects if the
+// template asks to. It returns the final response, when its request started and its
+// timings, and the redirects followed on the way.
+func roundTrip(rt http.RoundTripper, req *http.Request, reqInfo RequestInfo) (*http.Response, time.Time, *requestTimings, []RedirectHop, error) {
+	maxRedirects := int(reqInfo.MaxRedirects)
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	var hops []RedirectHop
+	for {
+		started := time.Now()
+		timings := &requestTimings{}
+		reqInfo.Jar.send(req)
+
+		ctx, headersReceived := withHeaderTimeout(req.Context(), reqInfo.Timeout.Header)
+		resp, err := rt.RoundTrip(traceRequest(req.WithContext(ctx), timings))
+		headersReceived()
+		if err != nil {
+			return nil, started, timings, hops, requestError(ctx, err)
+		}
+		reqInfo.Jar.record(req.URL, resp)
+
+		if !reqInfo.FollowRedirects || !isRedirect(resp.StatusCode) {
+			return resp, started, timings, hops, nil
+		}
+		location, err := resp.Location()
+		if err == http.ErrNoLocation {
+			return resp, started, timings, hops, nil
+		}
+
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainedBody))
+		resp.Body.Close()
+		if err != nil {
+			return nil, started, timings, hops, fmt.Errorf("invalid redirect location: %v", err)
+		}
+		if len(hops) >= maxRedirects {
+			return nil, started, timings, hops, fmt.Errorf("stopped after %v redirects", maxRedirects)
+		}
+
+		hops = append(hops, RedirectHop{
+			Url:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Elapsed:    time.Now().Sub(started).Seconds() * 1000,
+		})
+
+		if req, err = redirectRequest(req, resp.StatusCode, location.String(), reqInfo.Body, reqInfo.Jar); err != nil {
+			return nil, started, timings, hops, err
+		}
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRequest makes the request for the next hop, as browsers do: 307
+// and 308 repeat the request, while the others turn it into a GET without
+// a body.
+func redirectRequest(prev *http.Request, status int, location string, body string, jar *cookieJar) (*http.Request, error) {
+	method := prev.Method
+	if status != http.StatusTemporaryRedirect && status != http.StatusPermanentRedirect &&
+		method != "GET" && method != "HEAD" {
+		method, body = "GET", ""
+	}
+
+	req, err := http.NewRequestWithContext(prev.Context(), method, location, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = prev.Header.Clone()
+	// the cookie jar, if any, sets the cookies of each hop
+	if jar != nil {
+		req.Header.Del("Cookie")
+	}
+	if body == "" {
+		req.Header.Del("Content-Type")
+	}
+	// don't leak credentials to another host, as net/http's client doesn't
+	if req.URL.Host != prev.URL.Host {
+		for _, header := range []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"} {
+			req.Header.Del(header)
+		}
+	}
+	return req, nil
+}
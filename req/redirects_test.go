@@ -0,0 +1,56 @@
+package req
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedirectCookieHeader(t *testing.T) {
+	prev, err := http.NewRequest("GET", "http://example.com/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.Header.Set("Cookie", "session=abc")
+
+	// without a jar, the cookies of the template are sent to every hop
+	req, err := redirectRequest(prev, http.StatusFound, "http://example.com/home", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := req.Header.Get("Cookie"); cookie != "session=abc" {
+		t.Errorf("expected the cookie header to be kept, got '%v'", cookie)
+	}
+
+	// with a jar, it sets them for each hop
+	req, err = redirectRequest(prev, http.StatusFound, "http://example.com/home", "", newCookieJar(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := req.Header.Get("Cookie"); cookie != "" {
+		t.Errorf("expected the jar to set the cookies, got '%v'", cookie)
+	}
+}
+
+func TestRedirectToAnotherHost(t *testing.T) {
+	prev, err := http.NewRequest("GET", "http://example.com/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.Header.Set("Cookie", "session=abc")
+	prev.Header.Set("Authorization", "Bearer abc")
+	prev.Header.Set("Www-Authenticate", "Basic")
+	prev.Header.Set("X-Request-Id", "42")
+
+	req, err := redirectRequest(prev, http.StatusFound, "http://other.example.com/home", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []string{"Cookie", "Authorization", "Www-Authenticate"} {
+		if value := req.Header.Get(header); value != "" {
+			t.Errorf("expected %v not to be sent to another host, got '%v'", header, value)
+		}
+	}
+	if id := req.Header.Get("X-Request-Id"); id != "42" {
+		t.Errorf("expected the other headers to be kept, got '%v'", id)
+	}
+}
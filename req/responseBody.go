@@ -14,61 +14,70 @@ import (
 	"time"
 )
 
-var arrayIndexRe *regexp.Regexp
+var accessorRe, arrayIndexRe *regexp.Regexp
 
 func init() {
-	arrayIndexRe = regexp.MustCompile("\\[(\\d+)\\]")
+	accessorRe = regexp.MustCompile("^([^\\[]*)((?:\\[-?\\d+\\])*)$")
+	arrayIndexRe = regexp.MustCompile("\\[(-?\\d+)\\]")
 }
 
 type responseBodyInfo struct {
-	Elapsed   time.Duration
-	Length    int64
-	Variables Variables
+	Elapsed    time.Duration
+	Length     int64
+	Variables  Variables
+	Body       []byte
+	Parsed     interface{}
+	ParseError error
 }
 
-func parseResponseBody(res *http.Response, captures []ResponseCapture) (responseBodyInfo, error) {
+func parseResponseBody(res *http.Response, captures []ResponseCapture, checks []ResponseCheck) (responseBodyInfo, error) {
 	started := time.Now()
 
-	vars := Variables{}
-	saveBody := shouldSaveBody(captures)
+	saveBody := shouldSaveBody(captures, checks)
 	length, body, err := readContent(res, saveBody)
 	if err != nil {
 		return responseBodyInfo{}, err
 	}
 	elapsed := time.Now().Sub(started)
 
-	var parsedBody interface{} = nil
-	if saveBody {
-		err := parseBody(res, body, &parsedBody)
-		if err != nil {
-			return responseBodyInfo{}, err
-		}
+	info := responseBodyInfo{Elapsed: elapsed, Length: length, Body: body}
+	if shouldParseBody(captures, checks) {
+		info.ParseError = parseBody(res, body, &info.Parsed)
+	}
+
+	info.Variables, err = runCaptures(captures, res, &info)
+	if err != nil {
+		return responseBodyInfo{}, err
 	}
 
+	return info, nil
+}
+
+func shouldSaveBody(captures []ResponseCapture, checks []ResponseCheck) bool {
 	for _, capture := range captures {
-		name := capture.Name
-		if capture.Source == ResponseCaptureHeader {
-			val := res.Header.Get(name)
-			vars[name] = val
-		} else if capture.Source == ResponseCaptureBody {
-			val, err := traverseObject(parsedBody, capture.Expression)
-			if err != nil {
-				return responseBodyInfo{}, err
-			} else {
-				vars[name] = fmt.Sprintf("%v", val)
-			}
+		if capture.needsBody() {
+			return true
 		}
 	}
-
-	return responseBodyInfo{Elapsed: elapsed, Length: length, Variables: vars}, nil
+	for _, check := range checks {
+		if check.needsBody() {
+			return true
+		}
+	}
+	return false
 }
 
-func shouldSaveBody(captures []ResponseCapture) bool {
+func shouldParseBody(captures []ResponseCapture, checks []ResponseCheck) bool {
 	for _, capture := range captures {
 		if capture.Source == ResponseCaptureBody {
 			return true
 		}
 	}
+	for _, check := range checks {
+		if check.Json != "" {
+			return true
+		}
+	}
 	return false
 }
 
@@ -95,8 +104,8 @@ func parseBody(res *http.Response, body []byte, data interface{}) error {
 		return fmt.Errorf("unable to parse content type '%s': %#v", contentType, err)
 	}
 
-	switch mediaType {
-	case "application/json":
+	switch {
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
 		err = json.Unmarshal(body, data)
 	default:
 		err = fmt.Errorf("unsupported media type")
@@ -109,61 +118,133 @@ func parseBody(res *http.Response, body []byte, data interface{}) error {
 	}
 }
 
-func traverseObject(object interface{}, path string) (interface{}, error) {
-	hierarchy := strings.Split(path, ".")
-
-	var err error
+// bodyQuery is an expression into a parsed json body, compiled once.
+type bodyQuery struct {
+	expr string
+	path *jsonPath // nil for simple expressions
+}
 
-	for _, accessor := range hierarchy {
-		object, err = getChild(object, accessor)
-		if err != nil {
-			return nil, err
+func compileBodyQuery(expr string, syntax ExpressionSyntax) (*bodyQuery, error) {
+	switch syntax {
+	case ExpressionJsonPath:
+	case ExpressionSimple:
+		return &bodyQuery{expr: expr}, nil
+	case "":
+		if !strings.HasPrefix(expr, "$") {
+			return &bodyQuery{expr: expr}, nil
 		}
+	default:
+		return nil, fmt.Errorf("unknown expression syntax '%v'", syntax)
 	}
 
-	return object, nil
+	path, err := compileJsonPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &bodyQuery{expr: expr, path: &path}, nil
 }
 
-func getChild(parent interface{}, accessor string) (interface{}, error) {
-	arrayIndexRe := regexp.MustCompile("\\[\\-?(\\d+)\\]")
-	idxMatch := arrayIndexRe.FindStringSubmatch(accessor)
-	var err error = nil
-	if len(idxMatch) == 0 {
-		return getChildByKey(parent, accessor)
-	} else {
-		var index int
-		index, err = strconv.Atoi(idxMatch[1])
+func (query *bodyQuery) evaluate(object interface{}) (interface{}, error) {
+	if query.path == nil {
+		return traverseObject(object, query.expr)
+	}
+	return query.path.query(object)
+}
+
+// formatValue formats a json value as a variable: strings as is, and other
+// values as json, so that e.g. a captured list can be sent in a body.
+func formatValue(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
 		if err != nil {
-			return nil, err
-		} else {
-			return getChildByIndex(parent, index)
+			return fmt.Sprintf("%v", v)
 		}
+		return string(data)
 	}
 }
 
+// traverseObject evaluates a simple dotted path, such as "data.items[0].id".
+// Negative indexes count from the end of arrays.
+func traverseObject(object interface{}, path string) (interface{}, error) {
+	for _, accessor := range strings.Split(path, ".") {
+		match := accessorRe.FindStringSubmatch(accessor)
+		if match == nil {
+			return nil, fmt.Errorf("invalid accessor '%v' in '%v'", accessor, path)
+		}
+
+		var err error
+		if match[1] != "" {
+			if object, err = getChildByKey(object, match[1]); err != nil {
+				return nil, err
+			}
+		}
+		for _, idxMatch := range arrayIndexRe.FindAllStringSubmatch(match[2], -1) {
+			index, err := strconv.Atoi(idxMatch[1])
+			if err != nil {
+				return nil, err
+			}
+			if object, err = getChildByIndex(object, index); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return object, nil
+}
+
 func getChildByIndex(parent interface{}, index int) (interface{}, error) {
-	asArray := (parent).([]interface{})
+	asArray, ok := parent.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot index %v: not an array", describeJsonType(parent))
+	}
+
 	length := len(asArray)
 	if index < 0 {
-		index = length - index
+		index = length + index
 	}
-
-	if index >= length {
-		return nil, fmt.Errorf("Index %v out of range in %v", index, parent)
+	if index < 0 || index >= length {
+		return nil, fmt.Errorf("index %v out of range in array of length %v", index, length)
 	}
 
-	child := asArray[index]
-
-	return child, nil
+	return asArray[index], nil
 }
 
 func getChildByKey(parent interface{}, key string) (interface{}, error) {
-	asMap := (parent).(map[string]interface{})
-	child := asMap[key]
-	if child == nil {
-		return nil, fmt.Errorf("Key %v not found in %v", key, parent)
+	asMap, ok := parent.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot get key '%v' of %v: not an object", key, describeJsonType(parent))
+	}
+
+	child, ok := asMap[key]
+	if !ok {
+		return nil, fmt.Errorf("key '%v' not found", key)
 	}
-	// fmt.Printf("getChildByKey %v of %v => %v\n", key, parent, child)
 
 	return child, nil
 }
+
+func describeJsonType(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
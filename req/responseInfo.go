@@ -1,14 +1,66 @@
 package req
 
+import "time"
+
 type Variables map[string]interface{}
 
 type ResponseInfo struct {
-	Idx        uint      `json:"idx"`
-	Url        string    `json:"url"`
-	Timestamp  int64     `json:"timestamp"`
-	Elapsed    float64   `json:"elapsed"`
-	Length     int64     `json:"length"`
-	StatusCode int       `json:"statusCode"`
-	Error      string    `json:"error,omitempty"`
-	Variables  Variables `json:"-"`
+	Idx         uint          `json:"idx"`
+	Step        string        `json:"step,omitempty"`
+	Url         string        `json:"url"`
+	Timestamp   float64       `json:"timestamp"` // seconds since epoch, with millisecond precision
+	Elapsed     float64       `json:"elapsed"`
+	ScheduleLag float64       `json:"scheduleLag,omitempty"`
+	Latency     float64       `json:"latency,omitempty"`
+	DnsMs       float64       `json:"dnsMs,omitempty"`
+	ConnectMs   float64       `json:"connectMs,omitempty"`
+	TlsMs       float64       `json:"tlsMs,omitempty"`
+	TtfbMs      float64       `json:"ttfbMs,omitempty"`
+	BodyMs      float64       `json:"bodyMs,omitempty"`
+	ConnReused  bool          `json:"connReused,omitempty"`
+	Length      int64         `json:"length"`
+	StatusCode  int           `json:"statusCode"`
+	Error       string        `json:"error,omitempty"`
+	ErrorClass  string        `json:"errorClass,omitempty"` // e.g. "request timeout"
+	Checks      []CheckResult `json:"checks,omitempty"`
+	StatusCheck bool          `json:"statusCheck,omitempty"` // the template checks the status itself
+	Redirects   []RedirectHop `json:"redirects,omitempty"`
+	Attempts    uint          `json:"attempts,omitempty"`    // with a retry policy
+	Transaction bool          `json:"transaction,omitempty"` // the duration of a group of steps, not a request
+	Variables   Variables     `json:"-"`
+}
+
+// EffectiveLatency is the time from the moment the request was scheduled to
+// start, which only differs from the elapsed time for rate-based runs.
+func (res ResponseInfo) EffectiveLatency() float64 {
+	if res.Latency > 0 {
+		return res.Latency
+	}
+	return res.Elapsed
+}
+
+// Failed tells whether the request failed, either with an error, with an
+// error status or because one of its checks failed.
+func (res ResponseInfo) Failed() bool {
+	if res.Error != "" || res.BadStatus() {
+		return true
+	}
+	for _, check := range res.Checks {
+		if !check.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// BadStatus tells whether the response has an error status (4xx or 5xx),
+// unless its template has a status check, which then decides alone.
+func (res ResponseInfo) BadStatus() bool {
+	return res.StatusCode >= 400 && !res.StatusCheck
+}
+
+// timestamp returns the number of seconds since epoch, rounded to the
+// millisecond.
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()/int64(time.Millisecond)) / 1000
 }
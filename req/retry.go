@@ -0,0 +1,159 @@
+package req
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetries is the default number of retries of a retry policy.
+const maxRetries = 3
+
+const (
+	defaultBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+type RetryCondition string
+
+const (
+	RetryOnError   RetryCondition = "error"   // the connection failed
+	RetryOnTimeout RetryCondition = "timeout" // the request timed out
+	RetryOn5xx     RetryCondition = "5xx"
+	RetryOn429     RetryCondition = "429"
+)
+
+var allRetryConditions = []RetryCondition{RetryOnError, RetryOnTimeout, RetryOn5xx, RetryOn429}
+
+// RetryPolicy retries a request On some conditions (by default, all of
+// them), up to MaxAttempts in total. The delay before each retry grows
+// exponentially from Backoff, up to MaxBackoff, with a random jitter so that
+// the retries of many users don't hit the server all at once. A response's
+// Retry-After header overrides the delay, up to MaxBackoff.
+type RetryPolicy struct {
+	On          []RetryCondition
+	MaxAttempts uint
+	Backoff     Duration
+	MaxBackoff  Duration
+}
+
+func (policy *RetryPolicy) maxAttempts() int {
+	if policy.MaxAttempts == 0 {
+		return 1 + maxRetries
+	}
+	return int(policy.MaxAttempts)
+}
+
+func (policy *RetryPolicy) retriesOn(condition RetryCondition) bool {
+	conditions := policy.On
+	if len(conditions) == 0 {
+		conditions = allRetryConditions
+	}
+	for _, c := range conditions {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// delay is how long to wait before the given retry (from 1): a random
+// duration up to the exponential backoff ("full jitter"), unless the server
+// asked for a specific delay.
+func (policy *RetryPolicy) delay(retry int, retryAfter time.Duration) time.Duration {
+	maxBackoff := time.Duration(policy.MaxBackoff)
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	if retryAfter > 0 {
+		if retryAfter > maxBackoff {
+			return maxBackoff
+		}
+		return retryAfter
+	}
+
+	backoff := time.Duration(policy.Backoff)
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return time.Duration(randFloat64() * float64(backoff))
+}
+
+func (policy *RetryPolicy) validate() error {
+	for _, condition := range policy.On {
+		switch condition {
+		case RetryOnError, RetryOnTimeout, RetryOn5xx, RetryOn429:
+		default:
+			return fmt.Errorf("unknown retry condition '%v'", condition)
+		}
+	}
+	return nil
+}
+
+// attemptResult is the outcome of one attempt at a request, with what could
+// make it worth retrying.
+type attemptResult struct {
+	res        ResponseInfo
+	err        error
+	condition  RetryCondition // empty if the attempt didn't meet any
+	retryAfter time.Duration
+}
+
+// failedAttempt records an attempt which failed with err, because of cause,
+// or of a wrong request if cause is nil.
+func failedAttempt(reqInfo RequestInfo, cause error, err error) attemptResult {
+	res, err := badResponse(reqInfo, err)
+	result := attemptResult{res: res, err: err}
+	if cause != nil {
+		result.condition = errorCondition(cause)
+		var timeout timeoutError
+		if errors.As(cause, &timeout) {
+			result.res.ErrorClass = timeout.class()
+		}
+	}
+	return result
+}
+
+// errorCondition tells whether a failed round trip timed out.
+func errorCondition(err error) RetryCondition {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return RetryOnTimeout
+	}
+	return RetryOnError
+}
+
+func statusCondition(resp *http.Response) RetryCondition {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return RetryOn429
+	case resp.StatusCode >= 500 && resp.StatusCode <= 599:
+		return RetryOn5xx
+	}
+	return ""
+}
+
+// parseRetryAfter reads a Retry-After header, either in seconds or as a date.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(time.Now())
+	}
+	return 0
+}
@@ -1,19 +1,30 @@
 package req
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"text/template"
+	"time"
 
 	"github.com/imdario/mergo"
 )
 
 type RequestScenario struct {
-	Init     Variables
-	Bots     []BotInfo
-	Requests []RequestTemplate
-	Options  Options
+	Init       Variables
+	Bots       []BotInfo
+	Requests   []RequestTemplate
+	Sessions   *Sessions
+	Mix        *Mix
+	Deadline   Duration
+	Feeders    []Feeder
+	Thresholds []Threshold
+	Options    Options
+}
+
+// validate rejects the scenarios whose settings can't apply together.
+func (scen RequestScenario) validate() error {
+	if scen.Sessions != nil && scen.Mix != nil {
+		return fmt.Errorf("a scenario can't have both sessions and a mix")
+	}
+	return nil
 }
 
 type BotInfo struct {
@@ -22,16 +33,34 @@ type BotInfo struct {
 }
 
 type RequestTemplate struct {
-	Url         string
-	Method      string
-	Auth        string
-	AuthScheme  AuthScheme
-	Headers     map[string]string
-	Body        string
-	Captures    []ResponseCapture
-	Count       uint
-	Concurrency uint
-	StartIdx    uint
+	Name            string
+	Url             string
+	Method          string
+	Auth            string
+	AuthScheme      AuthScheme
+	Headers         map[string]string
+	Body            string
+	Captures        []ResponseCapture
+	Checks          []ResponseCheck
+	FollowRedirects bool
+	MaxRedirects    uint
+	Retry           *RetryPolicy
+	Timeout         Timeouts
+	Count           uint
+	Concurrency     uint
+	Duration        Duration
+	Rate            Rate
+	MaxInFlight     uint
+	Stages          []Stage
+	ThinkTime       ThinkTime
+	If              string
+	While           string
+	Repeat          uint
+	Group           []RequestTemplate
+	Weight          *uint
+	Thresholds      []Threshold
+	StartIdx        uint
+	IdxStride       uint
 }
 
 var requestTemplateDefaults = RequestTemplate{
@@ -43,112 +72,134 @@ var requestTemplateDefaults = RequestTemplate{
 	AuthScheme:  AuthSchemeBasic,
 	Count:       1,
 	Concurrency: 1,
+	MaxInFlight: 1000,
+	IdxStride:   1,
 }
 
-type ResponseCaptureSource string
-
-const (
-	ResponseCaptureHeader ResponseCaptureSource = "header"
-	ResponseCaptureBody   ResponseCaptureSource = "body"
-)
-
-type ResponseCapture struct {
-	Source     ResponseCaptureSource
-	Name       string
-	Expression string
+// requestGenerator lazily renders the requests of a template, so that long
+// or duration-based runs don't need to hold every request in memory. In a
+// mix, it renders one of several templates each time, chosen by weight.
+type requestGenerator struct {
+	tmpls     []*compiledTemplate
+	weights   []uint // cumulative weights of a mix
+	vars      Variables
+	feeders   *feederSet
+	startIdx  uint
+	idxStride uint
+	total     uint // 0 means unbounded
+	deadline  time.Time
+	generated uint
 }
 
-func generateRequestBatches(tmpl RequestTemplate, vars Variables) ([][]RequestInfo, error) {
-	if err := mergeTemplateWithDefaults(&tmpl); err != nil {
+// newRequestGenerator expects a template that was already merged with the
+// defaults.
+func newRequestGenerator(tmpl RequestTemplate, vars Variables, feeders *feederSet) (*requestGenerator, error) {
+	compiled, err := compileTemplate(tmpl)
+	if err != nil {
 		return nil, err
 	}
 
-	reqs, err := generateRequests(tmpl, vars)
-	if err != nil {
-		return nil, err
+	gen := &requestGenerator{
+		tmpls:     []*compiledTemplate{compiled},
+		vars:      mergeVariables(vars),
+		feeders:   feeders,
+		startIdx:  tmpl.StartIdx,
+		idxStride: tmpl.IdxStride,
+		total:     tmpl.Count * tmpl.Concurrency,
+	}
+	if tmpl.Duration > 0 {
+		gen.deadline = time.Now().Add(time.Duration(tmpl.Duration))
 	}
 
-	batches := batchify(reqs, tmpl.Concurrency)
-	return batches, nil
+	return gen, nil
 }
 
 func mergeTemplateWithDefaults(tmpl *RequestTemplate) error {
-	if err := mergo.Merge(tmpl, requestTemplateDefaults); err != nil {
-		return fmt.Errorf("Failed to merge template '%#v' with defaults: %v", tmpl, err)
-	}
-	return nil
+	return mergeLoadWithDefaults(tmpl, requestTemplateDefaults, tmpl.Stages, &tmpl.Duration, &tmpl.Count)
 }
 
-func generateRequests(tmpl RequestTemplate, vars Variables) ([]RequestInfo, error) {
-	totalCount := tmpl.Count * tmpl.Concurrency
-	reqs := make([]RequestInfo, 0, totalCount)
-
-	tmplJsonBytes, err := json.Marshal(tmpl)
-	if err != nil {
-		return nil, fmt.Errorf("unable to jsonify %#v: %v", tmpl, err)
+// mergeLoadWithDefaults merges the settings of a template or a mix with
+// their defaults. Stages define the duration, and a duration without an
+// explicit count runs until it elapses, rather than for the default count.
+func mergeLoadWithDefaults(load interface{}, defaults interface{}, stages []Stage, duration *Duration, count *uint) error {
+	if len(stages) > 0 {
+		*duration = stagesDuration(stages)
 	}
-	tmplJson := string(tmplJsonBytes)
+	unbounded := *duration > 0 && *count == 0
 
-	for i := uint(0); i < totalCount; i++ {
-		idx := tmpl.StartIdx + i
-		vars["idx"] = idx
-		req, err := renderTemplate(tmplJson, vars)
-		if err != nil {
-			return nil, err
-		}
-		req.Idx = idx
-		reqs = append(reqs, req)
+	if err := mergo.Merge(load, defaults); err != nil {
+		return fmt.Errorf("Failed to merge '%#v' with defaults: %v", load, err)
 	}
 
-	return reqs, nil
+	if unbounded {
+		*count = 0
+	}
+	return nil
 }
 
-func renderTemplate(tmplText string, vars Variables) (RequestInfo, error) {
-	reqInfo := RequestInfo{}
-
-	tmpl, err := template.New("_").Parse(tmplText)
-	if err != nil {
-		return reqInfo, fmt.Errorf("template parse error: '%v' => %v", tmplText, err)
+func (gen *requestGenerator) done() bool {
+	if gen.total > 0 && gen.generated >= gen.total {
+		return true
 	}
+	return !gen.deadline.IsZero() && !time.Now().Before(gen.deadline)
+}
 
-	buf := bytes.Buffer{}
-	err = tmpl.Execute(&buf, vars)
-	if err != nil {
-		return reqInfo, fmt.Errorf("template render error. text: '%v'; vars '%#v' => %v", tmplText, vars, err)
+// next renders the next request, for the virtual user with this cookie jar,
+// if any. ok is false once the template's count is reached, its duration
+// has elapsed or a feeder is exhausted.
+func (gen *requestGenerator) next(jar *cookieJar) (req RequestInfo, ok bool, err error) {
+	if gen.done() || !gen.feeders.draw(-1, gen.vars) {
+		return req, false, nil
 	}
 
-	err = json.Unmarshal(buf.Bytes(), &reqInfo)
+	idx := gen.startIdx + gen.generated*gen.idxStride
+	gen.vars["idx"] = idx
+	if jar != nil {
+		gen.vars["cookies"] = jar.variables()
+	}
+	req, err = gen.pick().render(gen.vars)
 	if err != nil {
-		return reqInfo, fmt.Errorf("unable to parse '%v' into request object: %v", buf.String(), err)
+		return req, false, err
 	}
+	req.Idx = idx
+	req.Jar = jar
+	gen.generated++
 
-	return reqInfo, err
+	return req, true, nil
 }
 
-func batchify(reqs []RequestInfo, batchSize uint) [][]RequestInfo {
-	totalCount := uint(len(reqs))
-	batchCount := uint(0.5 + float64(totalCount)/float64(batchSize))
-
-	batches := make([][]RequestInfo, 0, batchCount)
-	for i := uint(0); i < batchCount; i++ {
-		lo := i * batchSize
-		hi := umin((i+1)*batchSize, totalCount)
-		if hi-lo <= 0 {
-			panic(fmt.Sprintf("batchify error! totalCount: %v; batchSize: %v; batchCount: %v; i: %v; lo: %v; hi: %v",
-				totalCount, batchSize, batchCount, i, lo, hi))
+func (gen *requestGenerator) pick() *compiledTemplate {
+	if len(gen.tmpls) == 1 {
+		return gen.tmpls[0]
+	}
+	n := uint(randIntn(int(gen.weights[len(gen.weights)-1])))
+	for i, weight := range gen.weights {
+		if n < weight {
+			return gen.tmpls[i]
 		}
-		batch := reqs[lo:hi]
-		batches = append(batches, batch)
 	}
+	return gen.tmpls[len(gen.tmpls)-1]
+}
 
-	return batches
+// stepName identifies the responses of a template in the output: its name if
+// it has one, otherwise its position in the scenario, or in its group.
+func stepName(parent string, idx int, tmpl RequestTemplate) string {
+	if tmpl.Name != "" {
+		return tmpl.Name
+	}
+	if parent != "" {
+		return fmt.Sprintf("%v.%d", parent, idx+1)
+	}
+	return fmt.Sprintf("%d", idx+1)
 }
 
-func umin(x, y uint) uint {
-	if x < y {
-		return x
-	} else {
-		return y
+// walkTemplates calls fn with each template of a scenario, including those
+// of groups, and its step name.
+func walkTemplates(tmpls []RequestTemplate, parent string, fn func(name string, tmpl RequestTemplate)) {
+	for i, tmpl := range tmpls {
+		name := stepName(parent, i, tmpl)
+		fn(name, tmpl)
+		walkTemplates(tmpl.Group, name, fn)
 	}
 }
 
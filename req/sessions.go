@@ -0,0 +1,399 @@
+package req
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/imdario/mergo"
+)
+
+// Sessions runs a scenario as virtual users rather than step by step: each
+// user runs the whole list of requests in order, as a session, with its own
+// variables. Captured values are only seen by the rest of their session,
+// while the scenario's Init variables are shared by all sessions.
+//
+// Iterations is the total number of sessions, by default one per user; with
+// a Duration and no Iterations, sessions start until the duration elapses.
+// With Pacing, each user starts its sessions at that period, waiting after a
+// session which completed early.
+// Users, Rate and Stages shape the load as they do for a request template,
+// in sessions rather than requests. The Count, Concurrency, Duration, Rate
+// and Stages of the request templates are ignored, but not their ThinkTime.
+type Sessions struct {
+	Users      uint
+	Iterations uint
+	Duration   Duration
+	Rate       Rate
+	Stages     []Stage
+	Pacing     Duration
+	StartIdx   uint
+	IdxStride  uint
+}
+
+var sessionsDefaults = Sessions{
+	Users:     1,
+	IdxStride: 1,
+}
+
+func mergeSessionsWithDefaults(sessions *Sessions) error {
+	if len(sessions.Stages) > 0 {
+		sessions.Duration = stagesDuration(sessions.Stages)
+	}
+
+	// without a duration, each user runs one session by default
+	if sessions.Iterations == 0 && sessions.Duration == 0 {
+		sessions.Iterations = sessions.Users
+		if sessions.Iterations == 0 {
+			sessions.Iterations = sessionsDefaults.Users
+		}
+	}
+
+	if err := mergo.Merge(sessions, sessionsDefaults); err != nil {
+		return fmt.Errorf("Failed to merge sessions '%#v' with defaults: %v", sessions, err)
+	}
+	return nil
+}
+
+// sessionPlan holds the steps of the sessions, rendered for each session
+// with its own variables.
+type sessionPlan struct {
+	init    Variables
+	steps   []*flowStep
+	pacing  Duration
+	cookies bool
+}
+
+func newSessionPlan(scenario RequestScenario) (*sessionPlan, error) {
+	steps, err := compileFlow(scenario.Requests, "", true)
+	if err != nil {
+		return nil, err
+	}
+	plan := &sessionPlan{
+		init:    mergeVariables(scenario.Init),
+		steps:   steps,
+		pacing:  scenario.Sessions.Pacing,
+		cookies: scenario.Options.Cookies,
+	}
+	return plan, nil
+}
+
+// sessionRun is the state of a session while it runs its steps.
+type sessionRun struct {
+	ctx       context.Context
+	plan      *sessionPlan
+	idx       uint
+	fed       Variables
+	vars      Variables
+	scheduled time.Time
+	out       chan ResponseInfo
+	jar       *cookieJar
+	ended     bool
+}
+
+// run executes a session, with the rows its feeders drew, and its own cookie
+// jar. A failed request ends its session, as the next steps usually depend on
+// what it should have captured.
+func (plan *sessionPlan) run(ctx context.Context, idx uint, fed Variables, scheduled time.Time, out chan ResponseInfo) error {
+	run := &sessionRun{
+		ctx:       ctx,
+		plan:      plan,
+		idx:       idx,
+		fed:       fed,
+		vars:      Variables{},
+		scheduled: scheduled,
+		out:       out,
+		jar:       newCookieJar(plan.cookies),
+	}
+	_, err := run.steps(plan.steps)
+	return err
+}
+
+func (run *sessionRun) variables() Variables {
+	vars := Variables{"idx": run.idx}
+	if run.jar != nil {
+		vars["cookies"] = run.jar.variables()
+	}
+	return mergeVariables(vars, run.vars, run.fed, run.plan.init)
+}
+
+// steps runs a list of steps until the session ends, and returns the name of
+// the first step which failed, if any.
+func (run *sessionRun) steps(steps []*flowStep) (string, error) {
+	failedStep := ""
+	for _, step := range steps {
+		if skip, err := step.skipped(run.variables()); err != nil || skip {
+			if err != nil {
+				return failedStep, err
+			}
+			continue
+		}
+
+		for iteration := 0; ; iteration++ {
+			if step.looping() {
+				run.vars["iteration"] = iteration
+			}
+
+			failed, err := run.step(step)
+			if failedStep == "" {
+				failedStep = failed
+			}
+			if err != nil || run.ended {
+				return failedStep, err
+			}
+			step.tmpl.ThinkTime.pause(run.ctx)
+
+			again, err := step.loops(iteration+1, run.variables())
+			if err != nil {
+				return failedStep, err
+			}
+			if !again {
+				break
+			}
+		}
+	}
+	return failedStep, nil
+}
+
+func (run *sessionRun) step(step *flowStep) (string, error) {
+	// a stopped run doesn't start new requests
+	if run.ctx.Err() != nil {
+		run.ended = true
+		return "", nil
+	}
+	if step.group != nil {
+		started := time.Now()
+		failed, err := run.steps(step.group)
+		// an interrupted group didn't really take that long
+		if run.ctx.Err() == nil {
+			run.out <- transaction(run.idx, step.name, started, failed)
+		}
+		return failed, err
+	}
+
+	req, err := step.compiled.render(run.variables())
+	if err != nil {
+		return "", err
+	}
+	req.Idx = run.idx
+	req.Jar = run.jar
+	req.Scheduled, run.scheduled = run.scheduled, time.Time{}
+
+	res, ok := execRequestAndLog(run.ctx, req)
+	if !ok {
+		run.ended = true
+		return "", nil
+	}
+	run.out <- res
+	// a failed check or an error status fails the session as much as an error
+	run.ended = res.Failed()
+	run.vars = mergeVariables(res.Variables, run.vars)
+	if res.Failed() {
+		return step.name, nil
+	}
+	return "", nil
+}
+
+// sessionGenerator hands out the indexes and feeder rows of the sessions to
+// the users, until the iterations or a feeder are exhausted, the duration
+// elapsed or a session failed.
+type sessionGenerator struct {
+	sync.Mutex
+	feeders   *feederSet
+	startIdx  uint
+	idxStride uint
+	total     uint // 0 means unbounded
+	deadline  time.Time
+	generated uint
+	err       error
+}
+
+func newSessionGenerator(sessions Sessions, feeders *feederSet) *sessionGenerator {
+	gen := &sessionGenerator{
+		feeders:   feeders,
+		startIdx:  sessions.StartIdx,
+		idxStride: sessions.IdxStride,
+		total:     sessions.Iterations,
+	}
+	if sessions.Duration > 0 {
+		gen.deadline = time.Now().Add(time.Duration(sessions.Duration))
+	}
+	return gen
+}
+
+func (gen *sessionGenerator) done() bool {
+	gen.Lock()
+	defer gen.Unlock()
+	return gen.doneLocked()
+}
+
+func (gen *sessionGenerator) doneLocked() bool {
+	if gen.err != nil || (gen.total > 0 && gen.generated >= gen.total) {
+		return true
+	}
+	return !gen.deadline.IsZero() && !time.Now().Before(gen.deadline)
+}
+
+// next returns the index of the next session, and its feeder rows for the
+// given user (negative for sessions started at a rate, without users).
+func (gen *sessionGenerator) next(user int) (uint, Variables, bool) {
+	gen.Lock()
+	defer gen.Unlock()
+
+	fed := Variables{}
+	if gen.doneLocked() || !gen.feeders.draw(user, fed) {
+		return 0, nil, false
+	}
+	idx := gen.startIdx + gen.generated*gen.idxStride
+	gen.generated++
+	return idx, fed, true
+}
+
+// fail stops the generator, keeping the first error.
+func (gen *sessionGenerator) fail(err error) {
+	gen.Lock()
+	defer gen.Unlock()
+	if gen.err == nil {
+		gen.err = err
+	}
+}
+
+func execSessionsLocally(ctx context.Context, scenario RequestScenario, chans execChans) {
+	go func() {
+		defer func() { chans.Done <- true }()
+		if err := execSessionPlan(ctx, scenario, chans.Out); err != nil {
+			chans.Errs <- err
+		}
+	}()
+}
+
+func execSessionPlan(ctx context.Context, scenario RequestScenario, out chan ResponseInfo) error {
+	sessions := *scenario.Sessions
+	if err := mergeSessionsWithDefaults(&sessions); err != nil {
+		return err
+	}
+
+	plan, err := newSessionPlan(scenario)
+	if err != nil {
+		return err
+	}
+
+	feeders, err := newFeederSet(scenario.Feeders)
+	if err != nil {
+		return err
+	}
+
+	gen := newSessionGenerator(sessions, feeders)
+	profile := newLoadProfile(sessions.Users, sessions.Rate, sessions.Stages)
+	if !profile.rated {
+		if err := feeders.checkUsers(profile.maxConcurrency()); err != nil {
+			return err
+		}
+	}
+
+	var results chan ResponseInfo
+	var errs chan error
+	if profile.rated {
+		results, errs = execSessionsAtRate(ctx, plan, gen, profile)
+	} else {
+		results, errs = execSessions(ctx, plan, gen, profile)
+	}
+	for res := range results {
+		out <- res
+	}
+
+	return <-errs
+}
+
+// execSessions runs the sessions on a pool of virtual users, each starting
+// its next session as soon as the previous one completes. Users beyond the
+// profile's current concurrency idle.
+func execSessions(ctx context.Context, plan *sessionPlan, gen *sessionGenerator, profile loadProfile) (chan ResponseInfo, chan error) {
+	outCh := make(chan ResponseInfo)
+	errCh := make(chan error, 1)
+
+	go func() {
+		var wg sync.WaitGroup
+		for id := uint(0); id < profile.maxConcurrency(); id++ {
+			wg.Add(1)
+			go func(id uint) {
+				defer wg.Done()
+				execSessionWorker(ctx, id, plan, gen, profile, outCh)
+			}(id)
+		}
+		wg.Wait()
+		errCh <- gen.err
+		close(outCh)
+	}()
+
+	return outCh, errCh
+}
+
+func execSessionWorker(ctx context.Context, id uint, plan *sessionPlan, gen *sessionGenerator, profile loadProfile, out chan ResponseInfo) {
+	for ctx.Err() == nil {
+		if id >= profile.concurrencyAt(time.Now()) {
+			if gen.done() || !sleep(ctx, idleStep) {
+				return
+			}
+			continue
+		}
+
+		idx, fed, ok := gen.next(int(id))
+		if !ok {
+			return
+		}
+		started := time.Now()
+		if err := plan.run(ctx, idx, fed, time.Time{}, out); err != nil {
+			gen.fail(err)
+			return
+		}
+		if !gen.done() {
+			pace(ctx, plan.pacing, started)
+		}
+	}
+}
+
+// execSessionsAtRate starts sessions on a fixed schedule, regardless of how
+// long the previous ones take to complete (open model).
+func execSessionsAtRate(ctx context.Context, plan *sessionPlan, gen *sessionGenerator, profile loadProfile) (chan ResponseInfo, chan error) {
+	outCh := make(chan ResponseInfo)
+	errCh := make(chan error, 1)
+
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			errCh <- gen.err
+			close(outCh)
+		}()
+
+		scheduled := profile.started
+		if profile.rateAt(scheduled) <= 0 {
+			scheduled = profile.nextArrival(scheduled)
+		}
+
+		for {
+			if !sleep(ctx, scheduled.Sub(time.Now())) {
+				return
+			}
+
+			idx, fed, ok := gen.next(-1)
+			if !ok {
+				return
+			}
+
+			wg.Add(1)
+			go func(idx uint, fed Variables, scheduled time.Time) {
+				defer wg.Done()
+				if err := plan.run(ctx, idx, fed, scheduled, outCh); err != nil {
+					gen.fail(err)
+				}
+			}(idx, fed, scheduled)
+
+			scheduled = profile.nextArrival(scheduled)
+		}
+	}()
+
+	return outCh, errCh
+}
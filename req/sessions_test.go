@@ -0,0 +1,56 @@
+package req
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestFailedRequestEndsSession(t *testing.T) {
+	var lock sync.Mutex
+	paths := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths[r.URL.Path]++
+		lock.Unlock()
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{"ok": false}`))
+	}))
+	defer server.Close()
+
+	tests := map[string]RequestTemplate{
+		"status": {Url: server.URL + "/error"},
+		"check":  {Url: server.URL + "/fail", Checks: []ResponseCheck{{Json: "ok", Equals: true}}},
+	}
+	for name, failing := range tests {
+		paths = map[string]int{}
+		scen := RequestScenario{
+			Requests: []RequestTemplate{failing, {Url: server.URL + "/next"}},
+			Sessions: &Sessions{Users: 2},
+		}
+		if err := Execute(context.Background(), scen, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if paths["/next"] != 0 {
+			t.Errorf("%v: expected the failed sessions to end, got %v requests to the next step", name, paths["/next"])
+		}
+	}
+
+	// an expected error status doesn't fail the session
+	paths = map[string]int{}
+	scen := RequestScenario{
+		Requests: []RequestTemplate{{Url: server.URL + "/error", Checks: []ResponseCheck{{Status: "500"}}}, {Url: server.URL + "/next"}},
+		Sessions: &Sessions{Users: 2},
+	}
+	if err := Execute(context.Background(), scen, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if paths["/next"] != 2 {
+		t.Errorf("expected the sessions to go on, got %v requests to the next step", paths["/next"])
+	}
+}
@@ -0,0 +1,123 @@
+package req
+
+import (
+	"math"
+	"time"
+)
+
+// Stage ramps the load linearly from the previous stage's target (or from the
+// template's Concurrency/Rate for the first stage) to its own target, over
+// its duration. Templates with a Rate, or with rated stages, ramp the request
+// rate; other templates ramp the concurrency.
+type Stage struct {
+	Duration    Duration
+	Concurrency uint
+	Rate        Rate
+}
+
+const idleStep = 10 * time.Millisecond
+
+// loadProfile gives the target concurrency and rate of a template, or of the
+// sessions of a scenario, at any point of its execution.
+type loadProfile struct {
+	started     time.Time
+	concurrency float64
+	rate        float64 // requests per second
+	rated       bool
+	stages      []Stage
+}
+
+func newLoadProfile(concurrency uint, rate Rate, stages []Stage) loadProfile {
+	profile := loadProfile{
+		started:     time.Now(),
+		concurrency: float64(concurrency),
+		rate:        rate.PerSecond(),
+		rated:       !rate.IsZero(),
+		stages:      stages,
+	}
+
+	for _, stage := range stages {
+		if !stage.Rate.IsZero() {
+			profile.rated = true
+		}
+	}
+
+	return profile
+}
+
+func stagesDuration(stages []Stage) Duration {
+	var total Duration
+	for _, stage := range stages {
+		total += stage.Duration
+	}
+	return total
+}
+
+func (p loadProfile) end() time.Time {
+	return p.started.Add(time.Duration(stagesDuration(p.stages)))
+}
+
+func (p loadProfile) concurrencyAt(t time.Time) uint {
+	target := p.interpolate(t, p.concurrency, func(stage Stage) float64 {
+		return float64(stage.Concurrency)
+	})
+	return uint(math.Floor(target + 0.5))
+}
+
+func (p loadProfile) maxConcurrency() uint {
+	max := uint(p.concurrency)
+	for _, stage := range p.stages {
+		if stage.Concurrency > max {
+			max = stage.Concurrency
+		}
+	}
+	return max
+}
+
+func (p loadProfile) rateAt(t time.Time) float64 {
+	return p.interpolate(t, p.rate, func(stage Stage) float64 {
+		return stage.Rate.PerSecond()
+	})
+}
+
+func (p loadProfile) interpolate(t time.Time, initial float64, target func(stage Stage) float64) float64 {
+	elapsed := t.Sub(p.started)
+	from := initial
+
+	for _, stage := range p.stages {
+		to := target(stage)
+		duration := time.Duration(stage.Duration)
+		if elapsed < duration {
+			progress := float64(elapsed) / float64(duration)
+			return from + (to-from)*progress
+		}
+		elapsed -= duration
+		from = to
+	}
+
+	return from
+}
+
+// nextArrival returns when the request following the one scheduled at prev
+// should start, by walking the profile until it adds up to one request.
+// Periods with a null rate are skipped, up to the end of the stages.
+func (p loadProfile) nextArrival(prev time.Time) time.Time {
+	t := prev
+	owed := 1.0
+	for {
+		rate := p.rateAt(t)
+		steady := !t.Before(p.end())
+
+		if rate > 0 {
+			wait := time.Duration(owed / rate * float64(time.Second))
+			if steady || wait <= idleStep {
+				return t.Add(wait)
+			}
+		} else if steady {
+			return t
+		}
+
+		owed -= rate * idleStep.Seconds()
+		t = t.Add(idleStep)
+	}
+}
@@ -0,0 +1,302 @@
+package req
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// fieldTemplate is a field of a request template, parsed once. Fields
+// without any action are constant, and don't need to be executed.
+type fieldTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+func compileField(name string, text string, escapeJson bool) (fieldTemplate, error) {
+	field := fieldTemplate{text: text}
+	if !strings.Contains(text, "{{") {
+		return field, nil
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return field, fmt.Errorf("template parse error in %v: '%v' => %v", name, text, err)
+	}
+	if escapeJson {
+		escapeJsonStrings(tmpl.Tree.Root, false)
+	}
+	field.tmpl = tmpl
+	return field, nil
+}
+
+func (field fieldTemplate) render(vars Variables) (string, error) {
+	if field.tmpl == nil {
+		return field.text, nil
+	}
+
+	var buf bytes.Buffer
+	if err := field.tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("template render error. text: '%v'; vars '%#v' => %v", field.text, vars, err)
+	}
+	return buf.String(), nil
+}
+
+// escapeJsonStrings pipes the actions of a json template which are inside a
+// json string through jsonEscape, so that e.g. a variable containing a quote
+// can't break the body. Actions outside of strings, e.g. numbers, are left
+// untouched. It returns whether the end of the list is inside a string.
+func escapeJsonStrings(list *parse.ListNode, inString bool) bool {
+	if list == nil {
+		return inString
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			inString = scanJsonString(string(n.Text), inString)
+		case *parse.ActionNode:
+			if inString && len(n.Pipe.Decl) == 0 && !endsWithJsonEscape(n.Pipe) {
+				n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+					NodeType: parse.NodeCommand,
+					Pos:      n.Pos,
+					Args:     []parse.Node{parse.NewIdentifier("jsonEscape").SetPos(n.Pos)},
+				})
+			}
+		case *parse.IfNode:
+			escapeJsonStrings(n.ElseList, inString)
+			inString = escapeJsonStrings(n.List, inString)
+		case *parse.RangeNode:
+			escapeJsonStrings(n.ElseList, inString)
+			inString = escapeJsonStrings(n.List, inString)
+		case *parse.WithNode:
+			escapeJsonStrings(n.ElseList, inString)
+			inString = escapeJsonStrings(n.List, inString)
+		}
+	}
+	return inString
+}
+
+// scanJsonString returns whether the end of a json text is inside a string.
+func scanJsonString(text string, inString bool) bool {
+	for i := 0; i < len(text); i++ {
+		switch {
+		case inString && text[i] == '\\':
+			i++
+		case text[i] == '"':
+			inString = !inString
+		}
+	}
+	return inString
+}
+
+func endsWithJsonEscape(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) == 0 {
+		return false
+	}
+	cmd := pipe.Cmds[len(pipe.Cmds)-1]
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && ident.Ident == "jsonEscape"
+}
+
+// compiledTemplate is a request template whose fields are parsed once, then
+// rendered for each request.
+type compiledTemplate struct {
+	base     RequestInfo
+	url      fieldTemplate
+	method   fieldTemplate
+	auth     fieldTemplate
+	body     fieldTemplate
+	headers  []headerTemplate
+	captures []fieldTemplate
+	equals   []fieldTemplate
+	matches  []fieldTemplate
+}
+
+type headerTemplate struct {
+	name, value fieldTemplate
+}
+
+// compileTemplate expects a template that was already merged with the
+// defaults. Its url, method, auth, headers, body, capture expressions and
+// check expectations can be templated. When the body is json, values
+// rendered inside its strings are escaped.
+func compileTemplate(tmpl RequestTemplate) (*compiledTemplate, error) {
+	compiled := &compiledTemplate{
+		base: RequestInfo{
+			Name:            tmpl.Name,
+			AuthScheme:      tmpl.AuthScheme,
+			Captures:        append([]ResponseCapture(nil), tmpl.Captures...),
+			Checks:          append([]ResponseCheck(nil), tmpl.Checks...),
+			FollowRedirects: tmpl.FollowRedirects,
+			MaxRedirects:    tmpl.MaxRedirects,
+			Retry:           tmpl.Retry,
+			Timeout:         tmpl.Timeout,
+		},
+	}
+
+	if tmpl.Retry != nil {
+		if err := tmpl.Retry.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fields := []struct {
+		field *fieldTemplate
+		name  string
+		text  string
+	}{
+		{&compiled.url, "url", tmpl.Url},
+		{&compiled.method, "method", tmpl.Method},
+		{&compiled.auth, "auth", tmpl.Auth},
+	}
+	for _, f := range fields {
+		if *f.field, err = compileField(f.name, f.text, false); err != nil {
+			return nil, err
+		}
+	}
+
+	jsonBody := false
+	for name, value := range tmpl.Headers {
+		header := headerTemplate{}
+		if header.name, err = compileField("header", name, false); err != nil {
+			return nil, err
+		}
+		if header.value, err = compileField(name, value, false); err != nil {
+			return nil, err
+		}
+		compiled.headers = append(compiled.headers, header)
+
+		if http.CanonicalHeaderKey(name) == "Content-Type" && strings.Contains(strings.ToLower(value), "json") {
+			jsonBody = true
+		}
+	}
+
+	if compiled.body, err = compileField("body", tmpl.Body, jsonBody); err != nil {
+		return nil, err
+	}
+
+	// expressions are compiled once here, unless they are templated
+	for i, capture := range tmpl.Captures {
+		if err := capture.validate(); err != nil {
+			return nil, err
+		}
+		field, err := compileField("capture "+capture.Name, capture.Expression, false)
+		if err != nil {
+			return nil, err
+		}
+		compiled.captures = append(compiled.captures, field)
+
+		if field.tmpl == nil {
+			if compiled.base.Captures[i].compiled, err = capture.compile(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, check := range tmpl.Checks {
+		equals, _ := check.Equals.(string)
+		equalsField, err := compileField("check "+check.describe(), equals, false)
+		if err != nil {
+			return nil, err
+		}
+		matchesField, err := compileField("check "+check.describe(), check.Matches, false)
+		if err != nil {
+			return nil, err
+		}
+		compiled.equals = append(compiled.equals, equalsField)
+		compiled.matches = append(compiled.matches, matchesField)
+
+		if err := compiled.base.Checks[i].compile(matchesField.tmpl != nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return compiled, nil
+}
+
+func (compiled *compiledTemplate) render(vars Variables) (RequestInfo, error) {
+	req := compiled.base
+	var err error
+
+	if req.Url, err = compiled.url.render(vars); err != nil {
+		return req, err
+	}
+	if req.Method, err = compiled.method.render(vars); err != nil {
+		return req, err
+	}
+	if req.Auth, err = compiled.auth.render(vars); err != nil {
+		return req, err
+	}
+	if req.Body, err = compiled.body.render(vars); err != nil {
+		return req, err
+	}
+
+	req.Headers = make(map[string]string, len(compiled.headers))
+	for _, header := range compiled.headers {
+		name, err := header.name.render(vars)
+		if err != nil {
+			return req, err
+		}
+		if req.Headers[name], err = header.value.render(vars); err != nil {
+			return req, err
+		}
+	}
+
+	if req.Captures, err = compiled.renderCaptures(vars); err != nil {
+		return req, err
+	}
+	if req.Checks, err = compiled.renderChecks(vars); err != nil {
+		return req, err
+	}
+
+	return req, nil
+}
+
+// renderCaptures only copies the captures when some are templated, as most
+// aren't.
+func (compiled *compiledTemplate) renderCaptures(vars Variables) ([]ResponseCapture, error) {
+	captures, copied := compiled.base.Captures, false
+	for i, field := range compiled.captures {
+		if field.tmpl == nil {
+			continue
+		}
+		if !copied {
+			captures, copied = append([]ResponseCapture(nil), captures...), true
+		}
+		expr, err := field.render(vars)
+		if err != nil {
+			return nil, err
+		}
+		captures[i].Expression = expr
+	}
+	return captures, nil
+}
+
+func (compiled *compiledTemplate) renderChecks(vars Variables) ([]ResponseCheck, error) {
+	checks, copied := compiled.base.Checks, false
+	for i := range compiled.equals {
+		equals, matches := compiled.equals[i], compiled.matches[i]
+		if equals.tmpl == nil && matches.tmpl == nil {
+			continue
+		}
+		if !copied {
+			checks, copied = append([]ResponseCheck(nil), checks...), true
+		}
+
+		var err error
+		if equals.tmpl != nil {
+			if checks[i].Equals, err = equals.render(vars); err != nil {
+				return nil, err
+			}
+		}
+		if checks[i].Matches, err = matches.render(vars); err != nil {
+			return nil, err
+		}
+	}
+	return checks, nil
+}
@@ -0,0 +1,179 @@
+package req
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// benchGenerated is the number of requests generated by each benchmark
+// iteration, to show the cost of generating a long run.
+const benchGenerated = 1000000
+
+func newBenchTemplate(b *testing.B) RequestTemplate {
+	tmpl := RequestTemplate{
+		Url:    "http://localhost/users/{{ .idx }}?q={{ .query }}",
+		Method: "POST",
+		Headers: map[string]string{
+			"authorization": "Bearer {{ .token }}",
+			"content-type":  "application/json",
+		},
+		Body:  `{"name": "{{ .name }}", "idx": {{ .idx }}}`,
+		Count: benchGenerated,
+	}
+	if err := mergeTemplateWithDefaults(&tmpl); err != nil {
+		b.Fatal(err)
+	}
+	return tmpl
+}
+
+var benchVars = Variables{"query": "shoes", "token": "abcdef", "name": "Jane"}
+
+// renderReparsedTemplate is the former renderer: the whole template, as
+// json, parsed again for each request.
+func renderReparsedTemplate(tmplText string, vars Variables) (RequestInfo, error) {
+	reqInfo := RequestInfo{}
+
+	tmpl, err := template.New("_").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		return reqInfo, err
+	}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return reqInfo, err
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &reqInfo)
+	return reqInfo, err
+}
+
+func reportGenerationCost(b *testing.B, started time.Time) {
+	perRequest := time.Now().Sub(started) / time.Duration(b.N*benchGenerated)
+	b.ReportMetric(float64(perRequest.Nanoseconds()), "ns/req")
+}
+
+func BenchmarkGenerateCompiled(b *testing.B) {
+	tmpl := newBenchTemplate(b)
+	feeders, err := newFeederSet(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	started := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		gen, err := newRequestGenerator(tmpl, benchVars, feeders)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			_, ok, err := gen.next(nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+		}
+	}
+
+	reportGenerationCost(b, started)
+}
+
+func BenchmarkGenerateReparsed(b *testing.B) {
+	tmpl := newBenchTemplate(b)
+	tmplJson, err := json.Marshal(tmpl)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	started := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		vars := mergeVariables(benchVars)
+		for idx := 0; idx < benchGenerated; idx++ {
+			vars["idx"] = idx
+			if _, err := renderReparsedTemplate(string(tmplJson), vars); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	reportGenerationCost(b, started)
+}
+
+func TestEscapeJsonStrings(t *testing.T) {
+	vars := Variables{
+		"name":  `Jane "JJ" O'Hara`,
+		"path":  `C:\users`,
+		"n":     42,
+		"admin": true,
+		"tags":  []string{"a\"", "b"},
+	}
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		// actions in strings are escaped
+		{`{"name": "{{ .name }}"}`, `{"name": "Jane \"JJ\" O'Hara"}`},
+		{`{"path": "{{ .path }}", "n": 1}`, `{"path": "C:\\users", "n": 1}`},
+		{`{"greeting": "hi {{ .name }}!"}`, `{"greeting": "hi Jane \"JJ\" O'Hara!"}`},
+		// but not those outside of strings
+		{`{"n": {{ .n }}, "admin": {{ .admin }}}`, `{"n": 42, "admin": true}`},
+		{`{"n": {{ .n }}, "name": "{{ .name }}"}`, `{"n": 42, "name": "Jane \"JJ\" O'Hara"}`},
+		// escaped quotes don't end strings
+		{`{"quoted": "\"{{ .name }}\""}`, `{"quoted": "\"Jane \"JJ\" O'Hara\""}`},
+		{`{"a": "\\", "name": {{ .n }}}`, `{"a": "\\", "name": 42}`},
+		// actions already escaped aren't escaped twice
+		{`{"name": "{{ .name | jsonEscape }}"}`, `{"name": "Jane \"JJ\" O'Hara"}`},
+		// control structures
+		{`{"name": "{{ if .admin }}{{ .name }}{{ else }}{{ .path }}{{ end }}"}`, `{"name": "Jane \"JJ\" O'Hara"}`},
+		{`{"tags": [{{ range $i, $tag := .tags }}{{ if $i }}, {{ end }}"{{ $tag }}"{{ end }}]}`, `{"tags": ["a\"", "b"]}`},
+		{`{{ if .admin }}{"role": "admin", "n": {{ .n }}}{{ end }}`, `{"role": "admin", "n": 42}`},
+		{`{"name": "{{ with .name }}{{ . }}{{ end }}"}`, `{"name": "Jane \"JJ\" O'Hara"}`},
+		// declarations are left alone
+		{`{{ $x := .name }}{"name": "{{ $x }}"}`, `{"name": "Jane \"JJ\" O'Hara"}`},
+	}
+
+	for _, test := range tests {
+		field, err := compileField("body", test.body, true)
+		if err != nil {
+			t.Errorf("%v: %v", test.body, err)
+			continue
+		}
+		actual, err := field.render(vars)
+		if err != nil {
+			t.Errorf("%v: %v", test.body, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.body, test.expected, actual)
+		}
+		if !json.Valid([]byte(actual)) {
+			t.Errorf("%v: rendered invalid json %v", test.body, actual)
+		}
+	}
+}
+
+func TestCompileTemplateRejectsUnknownCaptureSource(t *testing.T) {
+	tmpl := RequestTemplate{
+		Url:      "http://localhost/",
+		Captures: []ResponseCapture{{Name: "id", Source: "bdy", Expression: "$.id"}},
+	}
+	if _, err := compileTemplate(tmpl); err == nil {
+		t.Errorf("expected an error for capture source %v", tmpl.Captures[0].Source)
+	}
+
+	tmpl.Captures[0].Source = ResponseCaptureBody
+	if _, err := compileTemplate(tmpl); err != nil {
+		t.Errorf("expected no error for capture source %v, got %v", tmpl.Captures[0].Source, err)
+	}
+}
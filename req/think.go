@@ -0,0 +1,115 @@
+package req
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type ThinkDistribution string
+
+const (
+	ThinkFixed       ThinkDistribution = "fixed"
+	ThinkUniform     ThinkDistribution = "uniform"
+	ThinkExponential ThinkDistribution = "exponential"
+)
+
+// ThinkTime is how long a virtual user pauses after a request, like a real
+// user reading a page: a fixed Duration, a uniform delay between Min and Max,
+// or an exponential delay with a mean of Duration, capped at Max if set. In
+// json, it is either a fixed duration, e.g. "2s", or an object.
+type ThinkTime struct {
+	Distribution ThinkDistribution
+	Duration     Duration
+	Min          Duration
+	Max          Duration
+}
+
+func (t ThinkTime) IsZero() bool {
+	return t.Duration == 0 && t.Min == 0 && t.Max == 0
+}
+
+func (t ThinkTime) MarshalJSON() ([]byte, error) {
+	if t.Distribution == "" || t.Distribution == ThinkFixed {
+		return json.Marshal(t.Duration)
+	}
+	type thinkTime ThinkTime
+	return json.Marshal(thinkTime(t))
+}
+
+func (t *ThinkTime) UnmarshalJSON(data []byte) error {
+	var fixed Duration
+	if err := json.Unmarshal(data, &fixed); err == nil {
+		*t = ThinkTime{Distribution: ThinkFixed, Duration: fixed}
+		return nil
+	}
+
+	type thinkTime ThinkTime
+	var obj thinkTime
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("invalid think time %v: %v", string(data), err)
+	}
+
+	switch obj.Distribution {
+	case "":
+		obj.Distribution = ThinkFixed
+		if obj.Max > 0 {
+			obj.Distribution = ThinkUniform
+		}
+	case ThinkFixed, ThinkUniform, ThinkExponential:
+	default:
+		return fmt.Errorf("invalid think time %v: unknown distribution '%v'", string(data), obj.Distribution)
+	}
+	if obj.Distribution == ThinkUniform && obj.Max < obj.Min {
+		return fmt.Errorf("invalid think time %v: max is lower than min", string(data))
+	}
+
+	*t = ThinkTime(obj)
+	return nil
+}
+
+// next draws the duration of a pause.
+func (t ThinkTime) next() time.Duration {
+	switch t.Distribution {
+	case ThinkUniform:
+		spread := float64(t.Max - t.Min)
+		return time.Duration(t.Min) + time.Duration(spread*randFloat64())
+	case ThinkExponential:
+		pause := time.Duration(float64(t.Duration) * randExpFloat64())
+		if t.Max > 0 && pause > time.Duration(t.Max) {
+			pause = time.Duration(t.Max)
+		}
+		return pause
+	default:
+		return time.Duration(t.Duration)
+	}
+}
+
+func (t ThinkTime) pause(ctx context.Context) {
+	if !t.IsZero() {
+		sleep(ctx, t.next())
+	}
+}
+
+// pace waits until period has elapsed since started, so that iterations
+// start at a fixed period, however long they took.
+func pace(ctx context.Context, period Duration, started time.Time) {
+	sleep(ctx, started.Add(time.Duration(period)).Sub(time.Now()))
+}
+
+// sleep waits for a duration, unless the run is cancelled first, and tells
+// whether it did.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,347 @@
+package req
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold is a pass/fail criterion on the results of a run, such as
+// "p95 < 300ms", "errorRate < 1%" or "rps > 200". In json, it is either the
+// expression itself, or an object with an Expression, and an Abort flag to
+// stop the run as soon as the threshold is clearly breached.
+//
+// Supported metrics are latency percentiles (p50, p99.9...), avg, min and
+// max, all in milliseconds unless suffixed with a unit; errorRate, in
+// percent; rps, in requests per second; and count.
+type Threshold struct {
+	Expression string
+	Abort      bool
+	metric     string
+	op         string
+	value      float64
+}
+
+var thresholdRe = regexp.MustCompile(`^\s*([\w.]+)\s*(<=|>=|<|>)\s*([\d.]+)\s*(\w+|%)?\s*$`)
+
+// thresholds can only abort a run once they have enough samples, and have
+// been breached at enough checks in a row, as the first responses of a run
+// are often slower, and a metric can recover from a bad patch
+const (
+	thresholdAbortMinCount = 100
+	thresholdAbortChecks   = 5
+)
+
+func ParseThreshold(expr string) (Threshold, error) {
+	matches := thresholdRe.FindStringSubmatch(expr)
+	if matches == nil {
+		return Threshold{}, fmt.Errorf("invalid threshold '%v': expected <metric> <op> <value>", expr)
+	}
+
+	metric, op, number, unit := matches[1], matches[2], matches[3], matches[4]
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return Threshold{}, fmt.Errorf("invalid threshold '%v': %v", expr, err)
+	}
+
+	if isLatencyMetric(metric) {
+		if unit != "" && unit != "ms" {
+			duration, err := time.ParseDuration(number + unit)
+			if err != nil {
+				return Threshold{}, fmt.Errorf("invalid threshold '%v': %v", expr, err)
+			}
+			value = duration.Seconds() * 1000
+		}
+	} else if metric == "errorRate" {
+		if unit != "" && unit != "%" {
+			return Threshold{}, fmt.Errorf("invalid threshold '%v': error rate is in %%", expr)
+		}
+	} else if metric != "rps" && metric != "count" {
+		return Threshold{}, fmt.Errorf("invalid threshold '%v': unknown metric %v", expr, metric)
+	}
+
+	return Threshold{Expression: strings.TrimSpace(expr), metric: metric, op: op, value: value}, nil
+}
+
+func isLatencyMetric(metric string) bool {
+	if metric == "avg" || metric == "min" || metric == "max" {
+		return true
+	}
+	_, ok := percentileOf(metric)
+	return ok
+}
+
+func percentileOf(metric string) (float64, bool) {
+	if !strings.HasPrefix(metric, "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(metric[1:], 64)
+	return p, err == nil && p >= 0 && p <= 100
+}
+
+func (t Threshold) MarshalJSON() ([]byte, error) {
+	if t.Abort {
+		return json.Marshal(map[string]interface{}{"Expression": t.Expression, "Abort": true})
+	}
+	return json.Marshal(t.Expression)
+}
+
+func (t *Threshold) UnmarshalJSON(data []byte) error {
+	var expr string
+	var abort bool
+
+	if err := json.Unmarshal(data, &expr); err != nil {
+		var obj struct {
+			Expression string
+			Abort      bool
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return fmt.Errorf("invalid threshold %v: %v", string(data), err)
+		}
+		expr, abort = obj.Expression, obj.Abort
+	}
+
+	parsed, err := ParseThreshold(expr)
+	if err != nil {
+		return err
+	}
+	parsed.Abort = abort
+	*t = parsed
+	return nil
+}
+
+// checkable tells whether the threshold can already be judged from partial
+// results: the rate and count are only known at the end.
+func (t Threshold) checkable() bool {
+	return t.metric != "rps" && t.metric != "count"
+}
+
+func (t Threshold) passes(actual float64) bool {
+	switch t.op {
+	case "<":
+		return actual < t.value
+	case "<=":
+		return actual <= t.value
+	case ">":
+		return actual > t.value
+	default:
+		return actual >= t.value
+	}
+}
+
+// ThresholdResult is the outcome of a threshold. Scope is empty for the
+// scenario's thresholds, and the step name for a template's thresholds.
+type ThresholdResult struct {
+	Scope     string
+	Threshold Threshold
+	Actual    float64
+	Passed    bool
+}
+
+func (r ThresholdResult) String() string {
+	status := "passed"
+	if !r.Passed {
+		status = "FAILED"
+	}
+	scope := ""
+	if r.Scope != "" {
+		scope = fmt.Sprintf("[%v] ", r.Scope)
+	}
+	return fmt.Sprintf("%v%v: %v (actual: %.4g)", scope, r.Threshold.Expression, status, r.Actual)
+}
+
+// ThresholdsError is returned by Execute when thresholds failed.
+type ThresholdsError struct {
+	Failed  []ThresholdResult
+	Aborted bool
+}
+
+func (e ThresholdsError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, result := range e.Failed {
+		msgs[i] = result.String()
+	}
+	prefix := "thresholds failed"
+	if e.Aborted {
+		prefix = "run aborted, thresholds failed"
+	}
+	return fmt.Sprintf("%v: %v", prefix, strings.Join(msgs, "; "))
+}
+
+// thresholdStats accumulates the figures that thresholds are checked against.
+type thresholdStats struct {
+	count   int
+	errors  int
+	latency *Histogram
+	started float64
+	ended   float64
+}
+
+func newThresholdStats() *thresholdStats {
+	return &thresholdStats{latency: NewHistogram(DurationScale)}
+}
+
+func (s *thresholdStats) record(res ResponseInfo) {
+	ended := res.Timestamp + res.EffectiveLatency()/1000
+	if s.count == 0 || res.Timestamp < s.started {
+		s.started = res.Timestamp
+	}
+	if ended > s.ended {
+		s.ended = ended
+	}
+
+	s.count++
+	if res.Failed() {
+		s.errors++
+	}
+	s.latency.Record(res.EffectiveLatency())
+}
+
+func (s *thresholdStats) value(metric string) float64 {
+	if p, ok := percentileOf(metric); ok {
+		return s.latency.Percentile(p)
+	}
+
+	switch metric {
+	case "avg":
+		return s.latency.Mean()
+	case "min":
+		return s.latency.Min()
+	case "max":
+		return s.latency.Max()
+	case "errorRate":
+		if s.count == 0 {
+			return 0
+		}
+		return 100 * float64(s.errors) / float64(s.count)
+	case "rps":
+		if s.ended <= s.started {
+			return 0
+		}
+		return float64(s.count) / (s.ended - s.started)
+	default:
+		return float64(s.count)
+	}
+}
+
+// thresholdSet checks the thresholds of a scenario and of its templates
+// against the responses, as they come.
+type thresholdSet struct {
+	global      []Threshold
+	globalStats *thresholdStats
+	stepNames   []string
+	steps       map[string][]Threshold
+	stepStats   map[string]*thresholdStats
+	abortable   bool
+	lastCheck   time.Time
+	breaches    map[string]int // consecutive breached checks, by scope and expression
+}
+
+const thresholdCheckInterval = time.Second
+
+func newThresholdSet(scen RequestScenario) *thresholdSet {
+	set := &thresholdSet{
+		global:      scen.Thresholds,
+		globalStats: newThresholdStats(),
+		steps:       map[string][]Threshold{},
+		stepStats:   map[string]*thresholdStats{},
+		breaches:    map[string]int{},
+	}
+
+	walkTemplates(scen.Requests, "", func(step string, tmpl RequestTemplate) {
+		if len(tmpl.Thresholds) > 0 {
+			set.stepNames = append(set.stepNames, step)
+			set.steps[step] = tmpl.Thresholds
+			set.stepStats[step] = newThresholdStats()
+		}
+	})
+
+	set.forEach(func(scope string, threshold Threshold, stats *thresholdStats) {
+		if threshold.Abort {
+			set.abortable = true
+		}
+	})
+
+	return set
+}
+
+func (set *thresholdSet) empty() bool {
+	return len(set.global) == 0 && len(set.steps) == 0
+}
+
+func (set *thresholdSet) forEach(fn func(scope string, threshold Threshold, stats *thresholdStats)) {
+	for _, threshold := range set.global {
+		fn("", threshold, set.globalStats)
+	}
+	for _, step := range set.stepNames {
+		for _, threshold := range set.steps[step] {
+			fn(step, threshold, set.stepStats[step])
+		}
+	}
+}
+
+func (set *thresholdSet) record(res ResponseInfo) {
+	if set.empty() {
+		return
+	}
+	// transactions only count for the thresholds of their group
+	if !res.Transaction {
+		set.globalStats.record(res)
+	}
+	if stats, ok := set.stepStats[res.Step]; ok {
+		stats.record(res)
+	}
+}
+
+// checkAbort returns an error if an abortable threshold is already clearly
+// breached: at thresholdAbortChecks checks in a row. It only checks once in
+// a while, as it isn't free.
+func (set *thresholdSet) checkAbort() error {
+	if !set.abortable || time.Now().Sub(set.lastCheck) < thresholdCheckInterval {
+		return nil
+	}
+	set.lastCheck = time.Now()
+
+	var failed []ThresholdResult
+	set.forEach(func(scope string, threshold Threshold, stats *thresholdStats) {
+		if !threshold.Abort || !threshold.checkable() || stats.count < thresholdAbortMinCount {
+			return
+		}
+		result := evaluateThreshold(scope, threshold, stats)
+		key := scope + "\x00" + threshold.Expression
+		if result.Passed {
+			delete(set.breaches, key)
+			return
+		}
+		set.breaches[key]++
+		if set.breaches[key] >= thresholdAbortChecks {
+			failed = append(failed, result)
+		}
+	})
+
+	if len(failed) > 0 {
+		return ThresholdsError{Failed: failed, Aborted: true}
+	}
+	return nil
+}
+
+func (set *thresholdSet) evaluate() []ThresholdResult {
+	var results []ThresholdResult
+	set.forEach(func(scope string, threshold Threshold, stats *thresholdStats) {
+		results = append(results, evaluateThreshold(scope, threshold, stats))
+	})
+	return results
+}
+
+func evaluateThreshold(scope string, threshold Threshold, stats *thresholdStats) ThresholdResult {
+	actual := stats.value(threshold.metric)
+	return ThresholdResult{
+		Scope:     scope,
+		Threshold: threshold,
+		Actual:    actual,
+		Passed:    threshold.passes(actual),
+	}
+}
@@ -0,0 +1,150 @@
+package req
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		expr   string
+		metric string
+		op     string
+		value  float64
+	}{
+		{"p95 < 300ms", "p95", "<", 300},
+		{"p95<300", "p95", "<", 300},
+		{"p99.9 <= 1.5s", "p99.9", "<=", 1500},
+		{"avg > 2", "avg", ">", 2},
+		{"min >= 100us", "min", ">=", 0.1},
+		{"max < 2m", "max", "<", 120000},
+		{"errorRate < 1%", "errorRate", "<", 1},
+		{"errorRate <= 0.5", "errorRate", "<=", 0.5},
+		{" rps >= 200 ", "rps", ">=", 200},
+		{"count > 10", "count", ">", 10},
+	}
+
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.expr)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		if threshold.metric != test.metric || threshold.op != test.op || threshold.value != test.value {
+			t.Errorf("%v: expected %v %v %v, got %v %v %v", test.expr, test.metric, test.op, test.value,
+				threshold.metric, threshold.op, threshold.value)
+		}
+	}
+}
+
+func TestParseThresholdInvalid(t *testing.T) {
+	for _, expr := range []string{"", "p95", "p95 = 300", "p95 < ", "p95 < 3parsecs", "p101 < 3", "errorRate < 1s", "latency < 3", "rps > -1"} {
+		if _, err := ParseThreshold(expr); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}
+
+func TestThresholdPasses(t *testing.T) {
+	tests := []struct {
+		expr   string
+		actual float64
+		passes bool
+	}{
+		{"p95 < 300", 299, true},
+		{"p95 < 300", 300, false},
+		{"p95 <= 300", 300, true},
+		{"rps > 200", 200, false},
+		{"rps >= 200", 200, true},
+		{"rps >= 200", 199.9, false},
+	}
+
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passes := threshold.passes(test.actual); passes != test.passes {
+			t.Errorf("%v with %v: expected %v, got %v", test.expr, test.actual, test.passes, passes)
+		}
+	}
+}
+
+func TestThresholdJson(t *testing.T) {
+	var thresholds []Threshold
+	data := `["p95 < 1s", {"expression": "errorRate < 5%", "abort": true}]`
+	if err := json.Unmarshal([]byte(data), &thresholds); err != nil {
+		t.Fatal(err)
+	}
+	if len(thresholds) != 2 || thresholds[0].value != 1000 || thresholds[0].Abort || !thresholds[1].Abort || thresholds[1].metric != "errorRate" {
+		t.Errorf("unexpected thresholds %#v", thresholds)
+	}
+
+	encoded, err := json.Marshal(thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `["p95 \u003c 1s",{"Abort":true,"Expression":"errorRate \u003c 5%"}]`; string(encoded) != expected {
+		t.Errorf("expected %v, got %v", expected, string(encoded))
+	}
+
+	if err := json.Unmarshal([]byte(`["p95 ~ 1s"]`), &thresholds); err == nil {
+		t.Errorf("expected an invalid threshold to fail")
+	}
+}
+
+func TestThresholdAbort(t *testing.T) {
+	threshold, err := ParseThreshold("errorRate < 10%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	threshold.Abort = true
+	set := newThresholdSet(RequestScenario{Thresholds: []Threshold{threshold}})
+
+	record := func(count int, failed bool) {
+		for i := 0; i < count; i++ {
+			res := ResponseInfo{StatusCode: 200}
+			if failed {
+				res.Error = "failed"
+			}
+			set.record(res)
+		}
+	}
+	// checks are at least a second apart: pretend the last one is older
+	check := func() error {
+		set.lastCheck = time.Time{}
+		return set.checkAbort()
+	}
+
+	// too few responses to judge
+	record(50, true)
+	for i := 0; i < thresholdAbortChecks; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("expected no abort with %v responses, got %v", set.globalStats.count, err)
+		}
+	}
+
+	// a breach must last
+	record(100, false)
+	for i := 0; i < thresholdAbortChecks-1; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("expected no abort after %v breached checks, got %v", i+1, err)
+		}
+	}
+
+	// a recovery starts over
+	record(1000, false)
+	if err := check(); err != nil {
+		t.Fatalf("expected no abort once recovered, got %v", err)
+	}
+	record(200, true)
+	for i := 0; i < thresholdAbortChecks-1; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("expected no abort after %v breached checks, got %v", i+1, err)
+		}
+	}
+	if err := check(); err == nil {
+		t.Errorf("expected an abort after %v breached checks", thresholdAbortChecks)
+	}
+}
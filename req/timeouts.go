@@ -0,0 +1,151 @@
+package req
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timeouts bound the time a request may take: to connect to the server, to
+// receive the response headers once the request is sent, and in total, including its
+// body and redirects. In json, it is either the total timeout, e.g. "5s", or
+// an object.
+type Timeouts struct {
+	Connect Duration
+	Header  Duration
+	Request Duration
+}
+
+func (t Timeouts) MarshalJSON() ([]byte, error) {
+	if t.Connect == 0 && t.Header == 0 {
+		return json.Marshal(t.Request)
+	}
+	type timeouts Timeouts
+	return json.Marshal(timeouts(t))
+}
+
+func (t *Timeouts) UnmarshalJSON(data []byte) error {
+	var total Duration
+	if err := json.Unmarshal(data, &total); err == nil {
+		*t = Timeouts{Request: total}
+		return nil
+	}
+
+	type timeouts Timeouts
+	var obj timeouts
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("invalid timeout %v: %v", string(data), err)
+	}
+	*t = Timeouts(obj)
+	return nil
+}
+
+// timeoutError is the error of a request which timed out, with the phase
+// that took too long.
+type timeoutError struct {
+	phase string
+	after Duration
+}
+
+func (err timeoutError) Error() string {
+	return fmt.Sprintf("%v timed out after %v", err.phase, err.after)
+}
+
+func (err timeoutError) Timeout() bool   { return true }
+func (err timeoutError) Temporary() bool { return true }
+
+// class is the error class of requests which timed out in this phase.
+func (err timeoutError) class() string {
+	return err.phase + " timeout"
+}
+
+// withTimeouts bounds a request's context by its connect and total timeouts.
+// The header timeout is set for each hop of the request, by roundTrip.
+func withTimeouts(ctx context.Context, timeouts Timeouts) (context.Context, context.CancelFunc) {
+	if timeouts.Connect > 0 {
+		ctx = context.WithValue(ctx, connectTimeoutKey{}, timeouts.Connect)
+	}
+	if timeouts.Request > 0 {
+		return context.WithTimeoutCause(ctx, time.Duration(timeouts.Request), timeoutError{"request", timeouts.Request})
+	}
+	return context.WithCancel(ctx)
+}
+
+// withHeaderTimeout cancels a request if its response headers don't arrive
+// in time. The timer starts once the request is written, so that neither
+// the dial nor the upload count, and the returned function stops it once
+// the headers arrived.
+func withHeaderTimeout(ctx context.Context, timeout Duration) (context.Context, func()) {
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	var lock sync.Mutex
+	var timer *time.Timer
+	stopped := false
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			// a request retried on a new connection is written again
+			if timer != nil {
+				timer.Stop()
+			}
+			if !stopped {
+				timer = time.AfterFunc(time.Duration(timeout), func() {
+					cancel(timeoutError{"header", timeout})
+				})
+			}
+		},
+	}
+
+	stop := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		stopped = true
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	return httptrace.WithClientTrace(ctx, trace), stop
+}
+
+type connectTimeoutKey struct{}
+
+// dialWithTimeout applies the connect timeout of the request, if any, on
+// top of the dialer's.
+func dialWithTimeout(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		timeout, ok := ctx.Value(connectTimeoutKey{}).(Duration)
+		if !ok {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ctx, cancel := context.WithTimeoutCause(ctx, time.Duration(timeout), timeoutError{"connect", timeout})
+		defer cancel()
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil && errors.Is(context.Cause(ctx), timeoutError{"connect", timeout}) {
+			return nil, timeoutError{"connect", timeout}
+		}
+		return conn, err
+	}
+}
+
+// requestError replaces the error of a request which was cancelled by one of
+// its timeouts with the timeout.
+func requestError(ctx context.Context, err error) error {
+	var timeout timeoutError
+	if errors.As(context.Cause(ctx), &timeout) {
+		return timeout
+	}
+	if errors.As(err, &timeout) {
+		return timeout
+	}
+	return err
+}
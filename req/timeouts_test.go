@@ -0,0 +1,49 @@
+package req
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderTimeoutStartsOnceSent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// a slow dial must not count towards the header timeout
+	slowDial := createTransport()
+	defer slowDial.CloseIdleConnections()
+	dial := slowDial.DialContext
+	slowDial.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		time.Sleep(300 * time.Millisecond)
+		return dial(ctx, network, addr)
+	}
+
+	send := func(url string) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqInfo := RequestInfo{Timeout: Timeouts{Header: Duration(200 * time.Millisecond)}}
+		resp, _, _, _, err := roundTrip(slowDial, req, reqInfo)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := send(server.URL + "/fast"); err != nil {
+		t.Errorf("expected the dial not to count, got %v", err)
+	}
+	if err := send(server.URL + "/slow"); err != (timeoutError{"header", Duration(200 * time.Millisecond)}) {
+		t.Errorf("expected a header timeout, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package req
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// requestTimings records when each phase of a request happened.
+type requestTimings struct {
+	lock         sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	connReused   bool
+}
+
+func traceRequest(req *http.Request, timings *requestTimings) *http.Request {
+	record := func(t *time.Time) {
+		timings.lock.Lock()
+		defer timings.lock.Unlock()
+		*t = time.Now()
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { record(&timings.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(&timings.dnsDone) },
+		ConnectStart: func(network, addr string) {
+			timings.lock.Lock()
+			defer timings.lock.Unlock()
+			// with several addresses, only the first attempt is timed
+			if timings.connectStart.IsZero() {
+				timings.connectStart = time.Now()
+			}
+		},
+		ConnectDone:          func(network, addr string, err error) { record(&timings.connectDone) },
+		TLSHandshakeStart:    func() { record(&timings.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { record(&timings.tlsDone) },
+		GotFirstResponseByte: func() { record(&timings.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			timings.lock.Lock()
+			defer timings.lock.Unlock()
+			timings.connReused = info.Reused
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (timings *requestTimings) apply(res *ResponseInfo, started time.Time) {
+	timings.lock.Lock()
+	defer timings.lock.Unlock()
+
+	res.DnsMs = elapsedMs(timings.dnsStart, timings.dnsDone)
+	res.ConnectMs = elapsedMs(timings.connectStart, timings.connectDone)
+	res.TlsMs = elapsedMs(timings.tlsStart, timings.tlsDone)
+	res.TtfbMs = elapsedMs(started, timings.firstByte)
+	res.ConnReused = timings.connReused
+}
+
+func elapsedMs(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from).Seconds() * 1000
+}
@@ -21,7 +21,7 @@ func createTransport() *http.Transport {
 
 	transport := &http.Transport{
 		Proxy:               http.ProxyFromEnvironment,
-		Dial:                dialer.Dial,
+		DialContext:         dialWithTimeout(dialer),
 		TLSHandshakeTimeout: 10 * time.Second,
 		MaxIdleConnsPerHost: 512,
 		TLSClientConfig:     &tls.Config{},
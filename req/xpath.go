@@ -0,0 +1,230 @@
+package req
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/antchfx/xpath"
+)
+
+// xmlNode is a node of a parsed xml document, which xpath expressions are
+// evaluated against.
+type xmlNode struct {
+	kind     xmlNodeKind
+	name     string
+	text     string
+	parent   *xmlNode
+	index    int // position among the children of the parent
+	children []*xmlNode
+	attrs    []*xmlNode
+}
+
+type xmlNodeKind int
+
+const (
+	xmlDocument xmlNodeKind = iota
+	xmlElement
+	xmlAttribute
+	xmlText
+)
+
+func parseXml(body []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	doc := &xmlNode{kind: xmlDocument}
+	current := doc
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse xml body: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			elem := &xmlNode{kind: xmlElement, name: t.Name.Local}
+			for _, attr := range t.Attr {
+				elem.attrs = append(elem.attrs, &xmlNode{kind: xmlAttribute, name: attr.Name.Local, text: attr.Value, parent: elem})
+			}
+			current.appendChild(elem)
+			current = elem
+		case xml.EndElement:
+			if current.parent != nil {
+				current = current.parent
+			}
+		case xml.CharData:
+			current.appendChild(&xmlNode{kind: xmlText, text: string(t)})
+		}
+	}
+	return doc, nil
+}
+
+func (node *xmlNode) appendChild(child *xmlNode) {
+	child.parent = node
+	child.index = len(node.children)
+	node.children = append(node.children, child)
+}
+
+// value is the string value of a node: the concatenated text of an element.
+func (node *xmlNode) value() string {
+	if node.kind == xmlAttribute || node.kind == xmlText {
+		return node.text
+	}
+	var buf bytes.Buffer
+	for _, child := range node.children {
+		buf.WriteString(child.value())
+	}
+	return buf.String()
+}
+
+// xmlNavigator walks a parsed document for the xpath package. It is on an
+// attribute of node when attr isn't -1.
+type xmlNavigator struct {
+	root *xmlNode
+	node *xmlNode
+	attr int
+}
+
+func newXmlNavigator(doc *xmlNode) *xmlNavigator {
+	return &xmlNavigator{root: doc, node: doc, attr: -1}
+}
+
+func (nav *xmlNavigator) NodeType() xpath.NodeType {
+	if nav.attr != -1 {
+		return xpath.AttributeNode
+	}
+	switch nav.node.kind {
+	case xmlDocument:
+		return xpath.RootNode
+	case xmlText:
+		return xpath.TextNode
+	}
+	return xpath.ElementNode
+}
+
+func (nav *xmlNavigator) LocalName() string {
+	if nav.attr != -1 {
+		return nav.node.attrs[nav.attr].name
+	}
+	return nav.node.name
+}
+
+func (nav *xmlNavigator) Prefix() string {
+	return ""
+}
+
+func (nav *xmlNavigator) Value() string {
+	if nav.attr != -1 {
+		return nav.node.attrs[nav.attr].text
+	}
+	return nav.node.value()
+}
+
+func (nav *xmlNavigator) Copy() xpath.NodeNavigator {
+	copied := *nav
+	return &copied
+}
+
+func (nav *xmlNavigator) MoveToRoot() {
+	nav.node = nav.root
+	nav.attr = -1
+}
+
+func (nav *xmlNavigator) MoveToParent() bool {
+	if nav.attr != -1 {
+		nav.attr = -1
+		return true
+	}
+	if nav.node.parent == nil {
+		return false
+	}
+	nav.node = nav.node.parent
+	return true
+}
+
+func (nav *xmlNavigator) MoveToNextAttribute() bool {
+	if nav.attr+1 >= len(nav.node.attrs) {
+		return false
+	}
+	nav.attr++
+	return true
+}
+
+func (nav *xmlNavigator) MoveToChild() bool {
+	if nav.attr != -1 || len(nav.node.children) == 0 {
+		return false
+	}
+	nav.node = nav.node.children[0]
+	return true
+}
+
+func (nav *xmlNavigator) MoveToFirst() bool {
+	return nav.moveToSibling(0)
+}
+
+func (nav *xmlNavigator) MoveToNext() bool {
+	return nav.moveToSibling(nav.node.index + 1)
+}
+
+func (nav *xmlNavigator) MoveToPrevious() bool {
+	return nav.moveToSibling(nav.node.index - 1)
+}
+
+func (nav *xmlNavigator) moveToSibling(index int) bool {
+	if nav.attr != -1 || nav.node.parent == nil {
+		return false
+	}
+	siblings := nav.node.parent.children
+	if index < 0 || index >= len(siblings) {
+		return false
+	}
+	nav.node = siblings[index]
+	return true
+}
+
+func (nav *xmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	target, ok := other.(*xmlNavigator)
+	if !ok || target.root != nav.root {
+		return false
+	}
+	*nav = *target
+	return true
+}
+
+// xpathQuery is a compiled XPath 1.0 expression.
+type xpathQuery struct {
+	expr *xpath.Expr
+}
+
+func compileXpath(expr string) (xpathQuery, error) {
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return xpathQuery{}, fmt.Errorf("invalid xpath expression '%v': %v", expr, err)
+	}
+	return xpathQuery{compiled}, nil
+}
+
+// query evaluates the expression, and returns the string value of its first
+// match, or its value when it isn't a node set, such as count(//item).
+func (query xpathQuery) query(doc *xmlNode) (string, error) {
+	switch val := query.expr.Evaluate(newXmlNavigator(doc)).(type) {
+	case *xpath.NodeIterator:
+		if !val.MoveNext() {
+			return "", fmt.Errorf("no match for '%v'", query.expr)
+		}
+		return val.Current().Value(), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case string:
+		return val, nil
+	}
+	return "", fmt.Errorf("unexpected result of '%v'", query.expr)
+}
@@ -0,0 +1,105 @@
+package req
+
+import (
+	"testing"
+)
+
+const xpathTestDoc = `<?xml version="1.0"?>
+<shop name="corner">
+	<item id="1" price="8"><name>hat</name><tag>new</tag></item>
+	<item id="2" price="60"><name>shoes</name></item>
+	<item id="3" price="5"><name>  wool   socks </name><tag>sale</tag><tag>new</tag></item>
+	<owner><name>jane</name></owner>
+</shop>`
+
+func TestXpath(t *testing.T) {
+	doc, err := parseXml([]byte(xpathTestDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		// axes
+		{"/shop/item/name", "hat"},
+		{"shop/item/name", "hat"},
+		{"//name", "hat"},
+		{"/shop//owner/name", "jane"},
+		{"/shop/@name", "corner"},
+		{"//item/@id", "1"},
+		{"//owner/name/..//name", "jane"},
+		{"//owner/./name", "jane"},
+		{"/shop/item/name/text()", "hat"},
+		{"/shop/*/name", "hat"},
+		{"//owner/node()", "jane"},
+		// predicates
+		{"//item[2]/name", "shoes"},
+		{"//item[last()]/name", "  wool   socks "},
+		{"//item[position() = 2]/@id", "2"},
+		{"//item[@id = '3']/tag[1]", "sale"},
+		{"//item[@price > 10]/name", "shoes"},
+		{"//item[@price < 10 and tag = 'sale']/@id", "3"},
+		{"//item[@price > 50 or name = 'hat']/@id", "1"},
+		{"//item[@price != 8]/@id", "2"},
+		{"//item[@price >= 60]/@id", "2"},
+		{"//item[@price <= 5]/@id", "3"},
+		{"//item[tag = 'new'][2]/@id", "3"},
+		{"//item[tag][last()]/@id", "3"},
+		// functions
+		{"//item[count(tag) = 2]/@id", "3"},
+		{"//item[not(tag)]/name", "shoes"},
+		{"//item[contains(name, 'socks')]/@id", "3"},
+		{"//item[starts-with(name, 'sh')]/@id", "2"},
+		{"//item[normalize-space(name) = 'wool socks']/@id", "3"},
+		{"//name[normalize-space() = 'wool socks']/../@id", "3"},
+		// expressions which aren't node sets
+		{"count(//tag)", "3"},
+		{"sum(//item/@price) div 2", "36.5"},
+		{"concat(//owner/name, '!')", "jane!"},
+		{"boolean(//item[@price > 50])", "true"},
+	}
+
+	for _, test := range tests {
+		path, err := compileXpath(test.expr)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		actual, err := path.query(doc)
+		if err != nil {
+			t.Errorf("%v: %v", test.expr, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%v: expected '%v', got '%v'", test.expr, test.expected, actual)
+		}
+	}
+}
+
+func TestXpathNoMatch(t *testing.T) {
+	doc, err := parseXml([]byte(xpathTestDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expr := range []string{"/item", "//item[4]", "//item[@price > 100]", "//owner/@id", "//item[not(name)]"} {
+		path, err := compileXpath(expr)
+		if err != nil {
+			t.Errorf("%v: %v", expr, err)
+			continue
+		}
+		if actual, err := path.query(doc); err == nil {
+			t.Errorf("%v: expected no match, got '%v'", expr, actual)
+		}
+	}
+}
+
+func TestXpathInvalid(t *testing.T) {
+	for _, expr := range []string{"//item[", "//item[@id = ]", "//item[count()]", "//item[unknown(name)]", "//item[@id = '1'"} {
+		if _, err := compileXpath(expr); err == nil {
+			t.Errorf("%v: expected an error", expr)
+		}
+	}
+}